@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saswatamcode/pingpong/extdb"
+	"go.yaml.in/yaml/v2"
+)
+
+// Policies for handling simulated DB errors in ping requests.
+const (
+	// dbPolicyFail fails the request with a status mapped from the error.
+	dbPolicyFail = "fail"
+	// dbPolicyRetry retries retryable errors with exponential backoff, then fails.
+	dbPolicyRetry = "retry"
+	// dbPolicyDegrade responds successfully, marking the response as degraded.
+	dbPolicyDegrade = "degrade"
+)
+
+// degradedHeader is set on responses served despite a DB error under the degrade policy.
+const degradedHeader = "X-Pingpong-Degraded"
+
+// dbErrorPolicy decides how simulated DB errors propagate into ping responses.
+type dbErrorPolicy struct {
+	policy  string
+	retries int
+	backoff time.Duration
+
+	outcomes     *prometheus.CounterVec
+	retriesTotal prometheus.Counter
+}
+
+// validateDBFailurePolicy returns an error if policy is not a known DB failure policy.
+func validateDBFailurePolicy(policy string) error {
+	switch policy {
+	case dbPolicyFail, dbPolicyRetry, dbPolicyDegrade:
+		return nil
+	}
+	return errors.Errorf("unknown DB failure policy %q, expected one of: %v, %v, %v", policy, dbPolicyFail, dbPolicyRetry, dbPolicyDegrade)
+}
+
+func newDBErrorPolicy(reg prometheus.Registerer, policy string, retries int, backoff time.Duration) (*dbErrorPolicy, error) {
+	if err := validateDBFailurePolicy(policy); err != nil {
+		return nil, err
+	}
+
+	p := &dbErrorPolicy{
+		policy:  policy,
+		retries: retries,
+		backoff: backoff,
+		outcomes: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "pong",
+			Name:      "db_outcomes_total",
+			Help:      "Total number of ping requests by the outcome of their simulated DB queries.",
+		}, []string{"outcome"}), // success, recovered, degraded, failed
+		retriesTotal: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Subsystem: "pong",
+			Name:      "db_retries_total",
+			Help:      "Total number of retried simulated DB queries.",
+		}),
+	}
+	for _, o := range []string{"success", "recovered", "degraded", "failed"} {
+		p.outcomes.WithLabelValues(o)
+	}
+	return p, nil
+}
+
+// Query runs query applying the policy. It returns false if the request failed
+// and a response was written, in which case the caller must not write anything else.
+// It returns degraded if the request is to be served successfully despite a DB error.
+func (p *dbErrorPolicy) Query(w http.ResponseWriter, r *http.Request, query func(context.Context) error) (degraded, ok bool) {
+	ctx := r.Context()
+	err := query(ctx)
+
+	retried := false
+	if p.policy == dbPolicyRetry {
+	retry:
+		for attempt := 0; err != nil && extdb.IsRetryable(err) && attempt < p.retries; attempt++ {
+			select {
+			case <-ctx.Done():
+				break retry
+			case <-time.After(p.backoff << attempt):
+			}
+			p.retriesTotal.Inc()
+			retried = true
+			err = query(ctx)
+		}
+	}
+
+	switch {
+	case err == nil && retried:
+		p.outcomes.WithLabelValues("recovered").Inc()
+		return false, true
+	case err == nil:
+		p.outcomes.WithLabelValues("success").Inc()
+		return false, true
+	case p.policy == dbPolicyDegrade:
+		p.outcomes.WithLabelValues("degraded").Inc()
+		slog.Warn("serving degraded ping response due to simulated db error",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"error_type", extdb.ErrorType(err),
+		)
+		w.Header().Set(degradedHeader, "db-"+extdb.ErrorType(err))
+		return true, true
+	}
+
+	p.outcomes.WithLabelValues("failed").Inc()
+	status := dbErrorStatus(err)
+	slog.Warn("ping request failed due to simulated db error",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"remote_addr", r.RemoteAddr,
+		"status", status,
+		"error_type", extdb.ErrorType(err),
+		"retried", retried,
+	)
+	w.WriteHeader(status)
+	return false, false
+}
+
+// queryDB runs the simulated DB queries of a ping request, unless the simulated cache hits.
+func queryDB(ctx context.Context) error {
+	if cache == nil {
+		return simulateDB(ctx)
+	}
+	_, err := cache.Get(ctx, simulateDB)
+	return err
+}
+
+// simulateDB simulates the DB queries of a ping request: a typical read operation
+// (e.g., fetching user data) or a payment flow writing in a transaction.
+func simulateDB(ctx context.Context) error {
+	if dbTx {
+		return simulateTx(ctx)
+	}
+	for _, q := range dbPlan {
+		for range q.Count {
+			if _, err := dbSimulator.SimulateQuery(ctx, q.Operation, q.Table); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// simulateTx runs the query plan in a transaction. It returns the first error, if any.
+func simulateTx(ctx context.Context) error {
+	tx, err := dbSimulator.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, q := range dbPlan {
+		for range q.Count {
+			if _, err := tx.Exec(ctx, q.Operation, q.Table); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// dbQuery is a step of the query plan executed by every ping request.
+type dbQuery struct {
+	Operation string `yaml:"operation"`
+	Table     string `yaml:"table"`
+	Count     int    `yaml:"count"`
+}
+
+// defaultDBPlan is the query plan without a config file: reading the user or,
+// in a transaction, a payment reading the user, recording the payment and updating the balance.
+func defaultDBPlan(tx bool) []dbQuery {
+	if tx {
+		return []dbQuery{
+			{Operation: "select", Table: "users", Count: 1},
+			{Operation: "insert", Table: "payments", Count: 1},
+			{Operation: "update", Table: "accounts", Count: 1},
+		}
+	}
+	return []dbQuery{{Operation: "select", Table: "users", Count: 1}}
+}
+
+// dbConfig is the DB simulation config file, e.g.:
+//
+//	profiles:
+//	  users.select:
+//	    latency: 100%2ms
+//	  orders.insert:
+//	    latency: 80%50ms,20%300ms
+//	    success_prob: 90
+//	    error_types: 70%deadlock,30%timeout
+//	    rows_affected: 100%1
+//	plan:
+//	  - {operation: select, table: users}
+//	  - {operation: insert, table: orders, count: 3}
+type dbConfig struct {
+	// Profiles override the flag configured behavior per table or operation, see extdb.SimulatorOpts.
+	Profiles map[string]extdb.ProfileOpts `yaml:"profiles"`
+	// Plan is the query plan executed by every ping request. Defaults to defaultDBPlan.
+	Plan []dbQuery `yaml:"plan"`
+}
+
+func loadDBConfig(file string) (dbConfig, error) {
+	var cfg dbConfig
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return cfg, errors.Wrap(err, "reading DB config file")
+		}
+		if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+			return cfg, errors.Wrapf(err, "parsing DB config file %v", file)
+		}
+	}
+
+	if len(cfg.Plan) == 0 {
+		cfg.Plan = defaultDBPlan(dbTx)
+	}
+	for i, q := range cfg.Plan {
+		switch q.Operation {
+		case "select", "insert", "update", "delete":
+		default:
+			return cfg, errors.Errorf("query plan step %d: unknown operation %q, expected one of: select, insert, update, delete", i, q.Operation)
+		}
+		if q.Table == "" {
+			return cfg, errors.Errorf("query plan step %d: table is required", i)
+		}
+		switch {
+		case q.Count < 0:
+			return cfg, errors.Errorf("query plan step %d: count must not be negative", i)
+		case q.Count == 0:
+			cfg.Plan[i].Count = 1
+		}
+	}
+	return cfg, nil
+}
+
+// statusClientClosedRequest is the non-standard status nginx uses for requests the client gave up on.
+const statusClientClosedRequest = 499
+
+// dbErrorStatus maps a simulated DB error to the HTTP status a service would respond with.
+func dbErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, extdb.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, extdb.ErrConnection), errors.Is(err, extdb.ErrPoolExhausted), errors.Is(err, extdb.ErrOverloaded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, extdb.ErrDeadlock), errors.Is(err, extdb.ErrSerialization), errors.Is(err, extdb.ErrTxAborted):
+		return http.StatusConflict
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
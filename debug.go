@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/version"
+	"github.com/saswatamcode/pingpong/exthttp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// redactedFlagNames matches flags whose values /debug/config does not reveal, as they may hold
+// credentials. Any header flag is redacted, e.g. for authorization headers.
+var redactedFlagNames = regexp.MustCompile(`header|token|password|secret|credential`)
+
+// addDebugEndpoints serves the pprof, /debug/config and /version endpoints of cmd, if enabled.
+// They are served on m, or on a separate server on --debug-listen-address added to the run group.
+func addDebugEndpoints(g *run.Group, m *http.ServeMux, instr exthttp.InstrumentationMiddleware, cmd *cobra.Command) {
+	if !debugEnabled {
+		return
+	}
+
+	debug := http.NewServeMux()
+	debug.Handle("/debug/pprof/", instr.NewHandler("/debug/pprof/", http.HandlerFunc(pprof.Index)))
+	debug.Handle("/debug/pprof/cmdline", instr.NewHandler("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline)))
+	debug.Handle("/debug/pprof/profile", instr.NewHandler("/debug/pprof/profile", http.HandlerFunc(pprof.Profile)))
+	debug.Handle("/debug/pprof/symbol", instr.NewHandler("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol)))
+	debug.Handle("/debug/pprof/trace", instr.NewHandler("/debug/pprof/trace", http.HandlerFunc(pprof.Trace)))
+	debug.Handle("/debug/config", instr.NewHandler("/debug/config", configHandler(cmd)))
+	debug.Handle("/version", instr.NewHandler("/version", http.HandlerFunc(handlerVersion)))
+
+	if debugListenAddress == "" {
+		m.Handle("/debug/", debug)
+		m.Handle("/version", debug)
+		return
+	}
+
+	srv := http.Server{Addr: debugListenAddress, Handler: debug}
+	g.Add(func() error {
+		slog.Info("starting debug HTTP server", "address", debugListenAddress)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return errors.Wrap(err, "starting debug web server")
+		}
+		// Keep the group running if the server was shut down by another actor.
+		return nil
+	}, func(error) {
+		shutdownServer(&srv)
+	})
+}
+
+// configHandler responds with the effective flag values of cmd as JSON, including defaults.
+func configHandler(cmd *cobra.Command) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		flags := map[string]string{}
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			if f.Name == "help" {
+				return
+			}
+			flags[f.Name] = redactFlag(cmd.Flags(), f)
+		})
+		writeJSON(w, map[string]any{"command": cmd.Name(), "flags": flags})
+	})
+}
+
+// redactFlag returns the value of f, redacted if its name suggests it may hold credentials,
+// whether set or not. URLs, also within slices and maps, are shown without their userinfo.
+func redactFlag(fs *pflag.FlagSet, f *pflag.Flag) string {
+	if redactedFlagNames.MatchString(f.Name) {
+		return "<redacted>"
+	}
+
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		values := sv.GetSlice()
+		for i, v := range values {
+			values[i] = redactURL(v)
+		}
+		return "[" + strings.Join(values, ",") + "]"
+	}
+	if f.Value.Type() == "stringToString" {
+		m, err := fs.GetStringToString(f.Name)
+		if err != nil {
+			return "<redacted>"
+		}
+		values := make([]string, 0, len(m))
+		for k, v := range m {
+			values = append(values, k+"="+redactURL(v))
+		}
+		slices.Sort(values)
+		return "[" + strings.Join(values, ",") + "]"
+	}
+	return redactURL(f.Value.String())
+}
+
+// redactURL returns v without userinfo if it is a URL, or v otherwise.
+func redactURL(v string) string {
+	if u, err := url.Parse(v); err == nil && u.User != nil {
+		u.User = nil
+		return u.String()
+	}
+	return v
+}
+
+// handlerVersion responds with the build information as JSON.
+func handlerVersion(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]string{
+		"version":    version.Version,
+		"revision":   version.GetRevision(),
+		"branch":     version.Branch,
+		"build_user": version.BuildUser,
+		"build_date": version.BuildDate,
+		"go_version": version.GoVersion,
+		"goos":       version.GoOS,
+		"goarch":     version.GoArch,
+		"tags":       version.GetTags(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		slog.Warn("failed to write JSON response", "error", err)
+	}
+}
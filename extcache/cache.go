@@ -0,0 +1,265 @@
+// Package extcache simulates a Redis or memcached-like cache in front of a slower backend,
+// e.g. the extdb simulator. Lookups either hit with a configured ratio or, with a keyspace,
+// hit or miss depending on an LRU of simulated keys, so the hit ratio follows from the key
+// distribution, the capacity and the TTL, and starts cold.
+package extcache
+
+import (
+	"container/list"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saswatamcode/pingpong/extrand"
+)
+
+// Results of cache lookups recorded in cache_requests_total.
+const (
+	// ResultHit means the value was cached.
+	ResultHit = "hit"
+	// ResultMiss means the value was loaded from the backend.
+	ResultMiss = "miss"
+	// ResultCoalesced means the value missed, but was loaded by a concurrent lookup of the same key.
+	ResultCoalesced = "coalesced"
+)
+
+// Opts configures the cache simulator.
+type Opts struct {
+	// Latency is the encoded latency of cache operations and probability in format:
+	// <probability>%<duration>,<probability>%<duration>...
+	Latency string
+
+	// HitRatio is the probability (in %) of a lookup hitting, used if Keyspace is zero.
+	HitRatio float64
+
+	// Keyspace is the number of distinct keys looked up. If set, lookups hit if their key
+	// is cached in an LRU of Capacity entries, instead of rolling HitRatio.
+	Keyspace int
+
+	// Capacity is the maximum number of cached entries, least recently used ones are evicted.
+	Capacity int
+
+	// KeySkew is the skew of the Zipf distribution keys are chosen with, has to be larger than 1.
+	// Larger values make few keys hot. Zero chooses keys uniformly.
+	KeySkew float64
+
+	// TTL is how long entries are cached. Zero means until evicted.
+	TTL time.Duration
+
+	// Coalesce makes concurrent misses of the same key wait for a single load instead of
+	// all loading from the backend, which is what a cache stampede looks like.
+	Coalesce bool
+}
+
+// DefaultOpts returns default cache options.
+func DefaultOpts() Opts {
+	return Opts{
+		Latency:  "90%500us,10%2ms",
+		HitRatio: 80,
+		Capacity: 1000,
+		KeySkew:  1.1,
+		Coalesce: true,
+	}
+}
+
+// Cache simulates a cache with configurable latency and hit ratio.
+type Cache struct {
+	metrics *Metrics
+	opts    Opts
+	latency *extrand.WeightedChoice[time.Duration]
+
+	mtx      sync.Mutex
+	zipf     *rand.Zipf
+	lru      *list.List // Of *entry, most recently used first.
+	entries  map[uint64]*list.Element
+	inflight map[uint64]*load
+}
+
+type entry struct {
+	key       uint64
+	expiresAt time.Time
+}
+
+// load is an in-flight load of a key, shared by coalesced lookups.
+type load struct {
+	done chan struct{}
+	err  error
+}
+
+// New creates a new cache simulator.
+func New(metrics *Metrics, opts Opts) (*Cache, error) {
+	latency, err := extrand.NewWeightedChoice(opts.Latency, time.ParseDuration)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing latency")
+	}
+
+	c := &Cache{
+		metrics:  metrics,
+		opts:     opts,
+		latency:  latency,
+		lru:      list.New(),
+		entries:  map[uint64]*list.Element{},
+		inflight: map[uint64]*load{},
+	}
+	if opts.Keyspace > 0 {
+		if opts.Capacity <= 0 {
+			return nil, errors.Errorf("capacity has to be positive with a keyspace, got %v", opts.Capacity)
+		}
+		if opts.KeySkew != 0 {
+			if opts.KeySkew <= 1 {
+				return nil, errors.Errorf("key skew has to be larger than 1, got %v", opts.KeySkew)
+			}
+			c.zipf = rand.NewZipf(rand.New(rand.NewSource(time.Now().UnixNano())), opts.KeySkew, 1, uint64(opts.Keyspace-1))
+		}
+		metrics.SetItems(0, float64(opts.Capacity))
+	}
+	return c, nil
+}
+
+// Get simulates looking up a value. On a miss, it calls load to fetch the value from the
+// backend and caches it if load succeeds. It returns the result of the lookup and the
+// error of load, or the context error if the context was done.
+func (c *Cache) Get(ctx context.Context, load func(context.Context) error) (string, error) {
+	start := time.Now()
+
+	if err := c.wait(ctx, "get"); err != nil {
+		return ResultMiss, err
+	}
+
+	if c.opts.Keyspace == 0 {
+		if rand.Float64()*100 < c.opts.HitRatio {
+			c.metrics.RecordRequest(ResultHit, time.Since(start).Seconds())
+			return ResultHit, nil
+		}
+		err := c.load(ctx, load)
+		c.metrics.RecordRequest(ResultMiss, time.Since(start).Seconds())
+		return ResultMiss, err
+	}
+
+	result, err := c.getKey(ctx, c.pickKey(), load)
+	c.metrics.RecordRequest(result, time.Since(start).Seconds())
+	return result, err
+}
+
+// getKey looks up key in the LRU, loading it on a miss. With Coalesce, concurrent misses
+// share the result of the first one, unless it failed with a context error.
+func (c *Cache) getKey(ctx context.Context, key uint64, loadFn func(context.Context) error) (string, error) {
+	now := time.Now()
+
+	c.mtx.Lock()
+	if el, ok := c.entries[key]; ok {
+		if e := el.Value.(*entry); c.opts.TTL == 0 || now.Before(e.expiresAt) {
+			c.lru.MoveToFront(el)
+			c.mtx.Unlock()
+			return ResultHit, nil
+		}
+		c.removeLocked(el, "expired")
+	}
+
+	if l, ok := c.inflight[key]; ok && c.opts.Coalesce {
+		c.mtx.Unlock()
+		select {
+		case <-ctx.Done():
+			return ResultCoalesced, ctx.Err()
+		case <-l.done:
+		}
+		// A load cancelled with the context of the lookup that started it says nothing
+		// about the backend, so look the key up again with our own context instead.
+		if errors.Is(l.err, context.Canceled) || errors.Is(l.err, context.DeadlineExceeded) {
+			return c.getKey(ctx, key, loadFn)
+		}
+		return ResultCoalesced, l.err
+	}
+	l := &load{done: make(chan struct{})}
+	if c.opts.Coalesce {
+		c.inflight[key] = l
+	}
+	c.mtx.Unlock()
+
+	l.err = c.load(ctx, loadFn)
+	if l.err == nil {
+		l.err = c.set(ctx, key)
+	}
+
+	if c.opts.Coalesce {
+		c.mtx.Lock()
+		delete(c.inflight, key)
+		c.mtx.Unlock()
+	}
+	close(l.done)
+	return ResultMiss, l.err
+}
+
+// load calls loadFn, recording metrics.
+func (c *Cache) load(ctx context.Context, loadFn func(context.Context) error) error {
+	c.metrics.IncInflightLoads()
+	defer c.metrics.DecInflightLoads()
+
+	start := time.Now()
+	err := loadFn(ctx)
+	status := "success"
+	if err != nil {
+		status = "error"
+	}
+	c.metrics.RecordLoad(status, time.Since(start).Seconds())
+	return err
+}
+
+// set caches key, evicting the least recently used entry if the cache is full.
+func (c *Cache) set(ctx context.Context, key uint64) error {
+	if err := c.wait(ctx, "set"); err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	e := &entry{key: key}
+	if c.opts.TTL > 0 {
+		e.expiresAt = time.Now().Add(c.opts.TTL)
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return nil
+	}
+	for c.lru.Len() >= c.opts.Capacity {
+		c.removeLocked(c.lru.Back(), "capacity")
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	c.metrics.SetItems(float64(c.lru.Len()), float64(c.opts.Capacity))
+	return nil
+}
+
+func (c *Cache) removeLocked(el *list.Element, reason string) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+	c.metrics.RecordEviction(reason)
+	c.metrics.SetItems(float64(c.lru.Len()), float64(c.opts.Capacity))
+}
+
+// pickKey chooses the key of a lookup.
+func (c *Cache) pickKey() uint64 {
+	if c.zipf == nil {
+		return uint64(rand.Intn(c.opts.Keyspace))
+	}
+	// rand.Zipf is not safe for concurrent use.
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.zipf.Uint64()
+}
+
+// wait simulates the latency of a cache operation.
+func (c *Cache) wait(ctx context.Context, operation string) error {
+	start := time.Now()
+	latency, _ := c.latency.Pick()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(latency):
+	}
+	c.metrics.RecordOperation(operation, time.Since(start).Seconds())
+	return nil
+}
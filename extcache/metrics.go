@@ -0,0 +1,163 @@
+package extcache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics holds cache operation metrics.
+type Metrics struct {
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	opDuration      *prometheus.HistogramVec
+	loadsTotal      *prometheus.CounterVec
+	loadDuration    prometheus.Histogram
+	inflightLoads   prometheus.Gauge
+	evictionsTotal  *prometheus.CounterVec
+	items           prometheus.Gauge
+	capacity        prometheus.Gauge
+}
+
+// NewMetrics creates a new instance of cache Metrics.
+// It registers the metrics with the provided registerer.
+func NewMetrics(reg prometheus.Registerer, durationBuckets []float64) *Metrics {
+	if durationBuckets == nil {
+		durationBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	}
+
+	const maxBucketNumber = 256
+	const bucketFactor = 1.1
+
+	m := &Metrics{
+		requestsTotal: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "cache",
+				Name:      "requests_total",
+				Help:      "Total number of cache lookups.",
+			},
+			[]string{"result"}, // hit, miss, coalesced
+		),
+
+		requestDuration: promauto.With(reg).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem:                      "cache",
+				Name:                           "request_duration_seconds",
+				Help:                           "Histogram of cache lookup durations, including loading the value on a miss.",
+				Buckets:                        durationBuckets,
+				NativeHistogramBucketFactor:    bucketFactor,
+				NativeHistogramMaxBucketNumber: maxBucketNumber,
+			},
+			[]string{"result"},
+		),
+
+		opDuration: promauto.With(reg).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem:                      "cache",
+				Name:                           "operation_duration_seconds",
+				Help:                           "Histogram of cache operation durations.",
+				Buckets:                        durationBuckets,
+				NativeHistogramBucketFactor:    bucketFactor,
+				NativeHistogramMaxBucketNumber: maxBucketNumber,
+			},
+			[]string{"operation"}, // get, set
+		),
+
+		loadsTotal: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "cache",
+				Name:      "loads_total",
+				Help:      "Total number of values loaded from the backend on a cache miss.",
+			},
+			[]string{"status"}, // success, error
+		),
+
+		loadDuration: promauto.With(reg).NewHistogram(
+			prometheus.HistogramOpts{
+				Subsystem:                      "cache",
+				Name:                           "load_duration_seconds",
+				Help:                           "Histogram of durations of loading values from the backend on a cache miss.",
+				Buckets:                        prometheus.ExponentialBuckets(0.001, 2, 14),
+				NativeHistogramBucketFactor:    bucketFactor,
+				NativeHistogramMaxBucketNumber: maxBucketNumber,
+			},
+		),
+
+		inflightLoads: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "cache",
+				Name:      "inflight_loads",
+				Help:      "Current number of values being loaded from the backend. Spikes on concurrent misses of the same keys show cache stampedes.",
+			},
+		),
+
+		evictionsTotal: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "cache",
+				Name:      "evictions_total",
+				Help:      "Total number of cache entries evicted.",
+			},
+			[]string{"reason"}, // capacity, expired
+		),
+
+		items: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "cache",
+				Name:      "items",
+				Help:      "Current number of cached entries.",
+			},
+		),
+
+		capacity: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "cache",
+				Name:      "capacity",
+				Help:      "Maximum number of cached entries, 0 if the cache is not driven by a keyspace.",
+			},
+		),
+	}
+	for _, r := range []string{ResultHit, ResultMiss, ResultCoalesced} {
+		m.requestsTotal.WithLabelValues(r)
+	}
+	for _, r := range []string{"capacity", "expired"} {
+		m.evictionsTotal.WithLabelValues(r)
+	}
+	return m
+}
+
+// RecordRequest records a cache lookup with its result and duration in seconds.
+func (m *Metrics) RecordRequest(result string, duration float64) {
+	m.requestsTotal.WithLabelValues(result).Inc()
+	m.requestDuration.WithLabelValues(result).Observe(duration)
+}
+
+// RecordOperation records the duration in seconds of a cache operation, e.g. get or set.
+func (m *Metrics) RecordOperation(operation string, duration float64) {
+	m.opDuration.WithLabelValues(operation).Observe(duration)
+}
+
+// RecordLoad records loading a value from the backend with its status and duration in seconds.
+func (m *Metrics) RecordLoad(status string, duration float64) {
+	m.loadsTotal.WithLabelValues(status).Inc()
+	m.loadDuration.Observe(duration)
+}
+
+// IncInflightLoads increments the in-flight loads gauge.
+func (m *Metrics) IncInflightLoads() {
+	m.inflightLoads.Inc()
+}
+
+// DecInflightLoads decrements the in-flight loads gauge.
+func (m *Metrics) DecInflightLoads() {
+	m.inflightLoads.Dec()
+}
+
+// RecordEviction records an evicted entry.
+func (m *Metrics) RecordEviction(reason string) {
+	m.evictionsTotal.WithLabelValues(reason).Inc()
+}
+
+// SetItems sets the number of cached entries and the capacity.
+func (m *Metrics) SetItems(items, capacity float64) {
+	m.items.Set(items)
+	m.capacity.Set(capacity)
+}
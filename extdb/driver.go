@@ -0,0 +1,310 @@
+package extdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DriverName is the name the simulator's database/sql driver is registered as.
+const DriverName = "pingpongsim"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver is a database/sql driver executing statements against a Simulator, so code using
+// *sql.DB can run against simulated latency and errors without a database.
+//
+// With sql.Open(DriverName, dsn) the DSN encodes the simulator options as
+// semicolon separated key=value pairs, e.g.:
+//
+//	latency=90%10ms,10%50ms;success_prob=95;error_types=50%timeout,50%connection;tx_conflict_prob=1
+//
+// Metrics of simulators created from a DSN are not registered anywhere. To expose them,
+// create a Simulator with registered Metrics and use sql.OpenDB(NewConnector(sim)) instead.
+// Connection pooling is left to database/sql, the simulator's pool should not be enabled.
+type Driver struct{}
+
+// Open implements driver.Driver.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector implements driver.DriverContext.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	opts, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	sim, err := NewSimulator(NewMetrics(nil, nil), opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnector(sim), nil
+}
+
+// ParseDSN parses simulator options from a DSN. Unset options use DefaultSimulatorOpts,
+// without connection pool simulation.
+func ParseDSN(dsn string) (SimulatorOpts, error) {
+	opts := DefaultSimulatorOpts()
+	opts.Pool = PoolOpts{}
+
+	for _, kv := range strings.Split(dsn, ";") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return SimulatorOpts{}, errors.Errorf("invalid DSN entry %q, expected key=value", kv)
+		}
+
+		var err error
+		switch strings.TrimSpace(k) {
+		case "latency":
+			opts.Latency = v
+		case "success_prob":
+			opts.SuccessProb, err = strconv.ParseFloat(v, 64)
+		case "error_types":
+			opts.ErrorTypes = v
+		case "rows_affected":
+			opts.RowsAffected = v
+		case "tx_conflict_prob":
+			opts.TxConflictProb, err = strconv.ParseFloat(v, 64)
+		default:
+			return SimulatorOpts{}, errors.Errorf("unknown DSN key %q", k)
+		}
+		if err != nil {
+			return SimulatorOpts{}, errors.Wrapf(err, "parse DSN key %q", k)
+		}
+	}
+	return opts, nil
+}
+
+// NewConnector returns a driver.Connector opening connections to the given simulator,
+// for use with sql.OpenDB.
+func NewConnector(sim *Simulator) driver.Connector {
+	return &connector{sim: sim}
+}
+
+type connector struct {
+	sim *Simulator
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &sqlConn{sim: c.sim}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return &Driver{}
+}
+
+// sqlConn is a simulated database/sql connection.
+type sqlConn struct {
+	sim *Simulator
+	tx  *Tx
+	bad bool
+}
+
+var (
+	_ driver.ConnBeginTx        = &sqlConn{}
+	_ driver.ExecerContext      = &sqlConn{}
+	_ driver.QueryerContext     = &sqlConn{}
+	_ driver.ConnPrepareContext = &sqlConn{}
+	_ driver.Validator          = &sqlConn{}
+	_ driver.SessionResetter    = &sqlConn{}
+)
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqlConn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	return &sqlStmt{conn: c, query: query}, nil
+}
+
+func (c *sqlConn) Close() error {
+	if c.tx != nil {
+		c.tx.Rollback()
+		c.tx = nil
+	}
+	return nil
+}
+
+// IsValid reports whether the connection can be reused. Connections that saw a simulated
+// connection error are discarded by database/sql.
+func (c *sqlConn) IsValid() bool {
+	return !c.bad
+}
+
+// ResetSession is called before a pooled connection is reused and rejects connections
+// that saw a simulated connection error.
+func (c *sqlConn) ResetSession(context.Context) error {
+	if c.bad {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("transaction already in progress")
+	}
+	tx, err := c.sim.BeginTx(ctx)
+	if err != nil {
+		return nil, c.checkErr(err)
+	}
+	c.tx = tx
+	return &sqlTx{conn: c}, nil
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	res, err := c.simulate(ctx, query)
+	if err != nil {
+		return nil, c.checkErr(err)
+	}
+	return driver.RowsAffected(res.RowsAffected), nil
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.simulate(ctx, query)
+	if err != nil {
+		return nil, c.checkErr(err)
+	}
+	return &sqlRows{n: res.RowsAffected}, nil
+}
+
+// simulate runs the statement in the current transaction, if any.
+func (c *sqlConn) simulate(ctx context.Context, query string) (QueryResult, error) {
+	operation, table := parseStatement(query)
+	if c.tx != nil {
+		return c.tx.Exec(ctx, operation, table)
+	}
+	return c.sim.SimulateQuery(ctx, operation, table)
+}
+
+// checkErr marks the connection as bad on connection errors and returns err.
+func (c *sqlConn) checkErr(err error) error {
+	if errors.Is(err, ErrConnection) {
+		c.bad = true
+	}
+	return err
+}
+
+// parseStatement extracts the operation and table from a SQL statement, e.g.
+// "SELECT * FROM users" results in "select" and "users".
+func parseStatement(query string) (operation, table string) {
+	fields := strings.Fields(strings.ToLower(query))
+	if len(fields) == 0 {
+		return "unknown", ""
+	}
+	operation = fields[0]
+
+	var keyword string
+	switch operation {
+	case "select", "delete":
+		keyword = "from"
+	case "insert":
+		keyword = "into"
+	case "update":
+		keyword = "update"
+	default:
+		return operation, ""
+	}
+	for i, f := range fields[:len(fields)-1] {
+		if f == keyword {
+			table = strings.Trim(fields[i+1], "`\"();")
+			break
+		}
+	}
+	return operation, table
+}
+
+type sqlStmt struct {
+	conn  *sqlConn
+	query string
+}
+
+var (
+	_ driver.StmtExecContext  = &sqlStmt{}
+	_ driver.StmtQueryContext = &sqlStmt{}
+)
+
+func (s *sqlStmt) Close() error  { return nil }
+func (s *sqlStmt) NumInput() int { return -1 }
+
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, nil)
+}
+
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, nil)
+}
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+type sqlTx struct {
+	conn *sqlConn
+}
+
+func (t *sqlTx) Commit() error {
+	tx := t.conn.tx
+	t.conn.tx = nil
+	if err := tx.Commit(context.Background()); err != nil {
+		return t.conn.checkErr(err)
+	}
+	return nil
+}
+
+func (t *sqlTx) Rollback() error {
+	t.conn.tx.Rollback()
+	t.conn.tx = nil
+	return nil
+}
+
+// sqlRows returns n rows with a single "id" column.
+type sqlRows struct {
+	n, i int
+}
+
+func (r *sqlRows) Columns() []string { return []string{"id"} }
+func (r *sqlRows) Close() error      { return nil }
+
+func (r *sqlRows) Next(dest []driver.Value) error {
+	if r.i >= r.n {
+		return io.EOF
+	}
+	r.i++
+	dest[0] = int64(r.i)
+	return nil
+}
+
+// CollectDBStats makes the connection_pool gauge report the connection pool statistics
+// of db, read on every collection.
+func (m *Metrics) CollectDBStats(db *sql.DB) {
+	m.db.Store(db)
+}
+
+// SetDBStats sets the connection pool statistics from database/sql stats.
+func (m *Metrics) SetDBStats(stats sql.DBStats) {
+	m.SetConnectionPool(float64(stats.OpenConnections), float64(stats.Idle), float64(stats.InUse), float64(stats.MaxOpenConnections))
+}
@@ -0,0 +1,276 @@
+package extdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseDSN(t *testing.T) {
+	opts, err := ParseDSN("latency=100%1ms; success_prob=99.5;error_types=100%deadlock;rows_affected=100%3;tx_conflict_prob=2;")
+	if err != nil {
+		t.Fatalf("parse DSN: %v", err)
+	}
+	if opts.Latency != "100%1ms" || opts.SuccessProb != 99.5 || opts.ErrorTypes != "100%deadlock" ||
+		opts.RowsAffected != "100%3" || opts.TxConflictProb != 2 {
+		t.Errorf("unexpected options parsed: %+v", opts)
+	}
+	if opts.Pool.MaxOpen != 0 {
+		t.Errorf("expected pool simulation to be disabled, got %+v", opts.Pool)
+	}
+}
+
+func TestOpenInvalidDSN(t *testing.T) {
+	for _, dsn := range []string{
+		"latency",
+		"bogus=1",
+		"success_prob=high",
+		"tx_conflict_prob=",
+		"latency=50%10ms",
+		"error_types=100%",
+	} {
+		if db, err := sql.Open(DriverName, dsn); err == nil {
+			_ = db.Close()
+			t.Errorf("%q: expected error", dsn)
+		}
+	}
+}
+
+func TestParseStatement(t *testing.T) {
+	for _, tc := range []struct {
+		query            string
+		operation, table string
+	}{
+		{query: "SELECT * FROM users WHERE id = 1", operation: "select", table: "users"},
+		{query: "select id from \"orders\";", operation: "select", table: "orders"},
+		{query: "INSERT INTO `orders` (id) VALUES (1)", operation: "insert", table: "orders"},
+		{query: "UPDATE users SET name = 'x'", operation: "update", table: "users"},
+		{query: "DELETE FROM sessions", operation: "delete", table: "sessions"},
+		{query: "SELECT 1", operation: "select"},
+		{query: "BEGIN", operation: "begin"},
+		{query: "  ", operation: "unknown"},
+	} {
+		operation, table := parseStatement(tc.query)
+		if operation != tc.operation || table != tc.table {
+			t.Errorf("%q: got %q, %q, want %q, %q", tc.query, operation, table, tc.operation, tc.table)
+		}
+	}
+}
+
+func TestDriverQueries(t *testing.T) {
+	db := openTestDB(t, "latency=100%0s;success_prob=100;rows_affected=100%3")
+
+	res, err := db.Exec("UPDATE users SET name = 'x'")
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 3 {
+		t.Errorf("got %d rows affected (err: %v), want 3", n, err)
+	}
+
+	rows, err := db.Query("SELECT id FROM users")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatalf("close rows: %v", err)
+	}
+	if len(ids) != 3 {
+		t.Errorf("got %d rows, want 3", len(ids))
+	}
+}
+
+func TestDriverErrors(t *testing.T) {
+	for errorType, sentinel := range map[string]error{
+		"timeout":               ErrTimeout,
+		"connection":            ErrConnection,
+		"deadlock":              ErrDeadlock,
+		"serialization_failure": ErrSerialization,
+		"pool_exhausted":        ErrPoolExhausted,
+		"overloaded":            ErrOverloaded,
+	} {
+		db := openTestDB(t, "latency=100%0s;success_prob=0;error_types=100%"+errorType)
+
+		_, err := db.Exec("INSERT INTO orders (id) VALUES (1)")
+		if !errors.Is(err, sentinel) {
+			t.Errorf("%s: exec: got %v, want %v", errorType, err, sentinel)
+		}
+		if ErrorType(err) != errorType {
+			t.Errorf("%s: got error type %q", errorType, ErrorType(err))
+		}
+		if _, err := db.Query("SELECT id FROM orders"); !errors.Is(err, sentinel) {
+			t.Errorf("%s: query: got %v, want %v", errorType, err, sentinel)
+		}
+	}
+}
+
+func TestDriverDiscardsBadConnections(t *testing.T) {
+	db := openTestDB(t, "latency=100%0s;success_prob=0;error_types=100%deadlock")
+
+	// Other errors keep the connection.
+	if _, err := db.Exec("DELETE FROM sessions"); !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("got %v, want %v", err, ErrDeadlock)
+	}
+	if open := db.Stats().OpenConnections; open != 1 {
+		t.Errorf("got %d open connections after deadlock, want 1", open)
+	}
+
+	db = openTestDB(t, "latency=100%0s;success_prob=0;error_types=100%connection")
+	if _, err := db.Exec("DELETE FROM sessions"); !errors.Is(err, ErrConnection) {
+		t.Fatalf("got %v, want %v", err, ErrConnection)
+	}
+	if open := db.Stats().OpenConnections; open != 0 {
+		t.Errorf("got %d open connections after connection error, want 0", open)
+	}
+
+	c := &sqlConn{}
+	if !c.IsValid() || c.ResetSession(context.Background()) != nil {
+		t.Error("expected new connection to be valid")
+	}
+	c.checkErr(newSimulatedError("connection", nil))
+	if c.IsValid() {
+		t.Error("expected connection to be invalid after connection error")
+	}
+	if err := c.ResetSession(context.Background()); !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("reset session: got %v, want %v", err, driver.ErrBadConn)
+	}
+}
+
+func TestDriverTransactions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("commit", func(t *testing.T) {
+		db := openTestDB(t, "latency=100%0s;success_prob=100")
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		if _, err := tx.Exec("INSERT INTO orders (id) VALUES (1)"); err != nil {
+			t.Fatalf("exec: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		if _, err := tx.Exec("INSERT INTO orders (id) VALUES (2)"); !errors.Is(err, sql.ErrTxDone) {
+			t.Errorf("exec after commit: got %v, want %v", err, sql.ErrTxDone)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		db := openTestDB(t, "latency=100%0s;success_prob=100")
+		for i := 0; i < 2; i++ {
+			// The connection is reused, so the second transaction only starts if the first one was cleared.
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatalf("begin %d: %v", i, err)
+			}
+			if _, err := tx.Exec("UPDATE users SET name = 'x'"); err != nil {
+				t.Fatalf("exec %d: %v", i, err)
+			}
+			if err := tx.Rollback(); err != nil {
+				t.Fatalf("rollback %d: %v", i, err)
+			}
+		}
+	})
+
+	t.Run("aborted", func(t *testing.T) {
+		db := openTestDB(t, "latency=100%0s;success_prob=0;error_types=100%deadlock")
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		if _, err := tx.Exec("UPDATE users SET name = 'x'"); !errors.Is(err, ErrDeadlock) {
+			t.Errorf("exec: got %v, want %v", err, ErrDeadlock)
+		}
+		if _, err := tx.Exec("UPDATE users SET name = 'y'"); !errors.Is(err, ErrTxAborted) {
+			t.Errorf("exec after failure: got %v, want %v", err, ErrTxAborted)
+		}
+		if err := tx.Commit(); !errors.Is(err, ErrTxAborted) {
+			t.Errorf("commit: got %v, want %v", err, ErrTxAborted)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		db := openTestDB(t, "latency=100%0s;success_prob=100;tx_conflict_prob=100")
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		if _, err := tx.Exec("INSERT INTO orders (id) VALUES (1)"); err != nil {
+			t.Fatalf("exec: %v", err)
+		}
+		if err := tx.Commit(); !errors.Is(err, ErrSerialization) || !IsRetryable(err) {
+			t.Errorf("commit: got %v, want retryable %v", err, ErrSerialization)
+		}
+	})
+}
+
+func TestCollectDBStats(t *testing.T) {
+	opts, err := ParseDSN("latency=100%0s;success_prob=100")
+	if err != nil {
+		t.Fatalf("parse DSN: %v", err)
+	}
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg, nil)
+	sim, err := NewSimulator(m, opts)
+	if err != nil {
+		t.Fatalf("create simulator: %v", err)
+	}
+	db := sql.OpenDB(NewConnector(sim))
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetMaxOpenConns(7)
+	m.CollectDBStats(db)
+
+	if _, err := db.Exec("SELECT 1"); err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	got := map[string]float64{}
+	for _, mf := range mfs {
+		if mf.GetName() != MetricConnectionPool {
+			continue
+		}
+		for _, metric := range mf.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "state" {
+					got[l.GetValue()] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	want := map[string]float64{"open": 1, "idle": 1, "in_use": 0, "max": 7}
+	for state, v := range want {
+		if got[state] != v {
+			t.Errorf("%s %s: got %v, want %v", MetricConnectionPool, state, got[state], v)
+		}
+	}
+}
+
+// openTestDB opens a database/sql handle to a simulator configured by dsn, limited to a
+// single connection so that tests see connection reuse.
+func openTestDB(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(DriverName, dsn)
+	if err != nil {
+		t.Fatalf("open %q: %v", dsn, err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetMaxOpenConns(1)
+	return db
+}
@@ -0,0 +1,99 @@
+package extdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Sentinel errors wrapped by simulated query failures, to be checked with errors.Is.
+var (
+	ErrTimeout       = errors.New("query timeout")
+	ErrConnection    = errors.New("connection failure")
+	ErrDeadlock      = errors.New("deadlock detected")
+	ErrSerialization = errors.New("serialization failure")
+	ErrPoolExhausted = errors.New("connection pool exhausted")
+	ErrOverloaded    = errors.New("database overloaded")
+	ErrTxAborted     = errors.New("transaction aborted")
+	ErrTxDone        = errors.New("transaction already committed or rolled back")
+)
+
+// errorTypeSentinels maps error types, as used in ErrorTypes and the error_type label, to sentinel errors.
+var errorTypeSentinels = map[string]error{
+	"timeout":               ErrTimeout,
+	"connection":            ErrConnection,
+	"deadlock":              ErrDeadlock,
+	"serialization_failure": ErrSerialization,
+	"pool_exhausted":        ErrPoolExhausted,
+	"overloaded":            ErrOverloaded,
+	"tx_aborted":            ErrTxAborted,
+	"tx_done":               ErrTxDone,
+}
+
+// SimulatedError represents a simulated database error.
+type SimulatedError struct {
+	// Type is the error type, e.g. "timeout" or "deadlock".
+	Type    string
+	Message string
+
+	// Err is the underlying error, one of the sentinel errors or a context error.
+	// It is nil for custom error types.
+	Err error
+}
+
+// newSimulatedError creates a SimulatedError of the given type. If cause is nil,
+// the sentinel error of the type is used.
+func newSimulatedError(errorType string, cause error) SimulatedError {
+	if cause == nil {
+		cause = errorTypeSentinels[errorType]
+	}
+	e := SimulatedError{Type: errorType, Message: "simulated failure", Err: cause}
+	if cause != nil {
+		e.Message = cause.Error()
+	}
+	return e
+}
+
+func (e SimulatedError) Error() string {
+	return fmt.Sprintf("simulated db error [%s]: %s", e.Type, e.Message)
+}
+
+// Unwrap returns the underlying sentinel or context error.
+func (e SimulatedError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorType returns the simulated error type of err, "context_cancelled" for context errors
+// and "unknown" for any other error.
+func ErrorType(err error) string {
+	var simErr SimulatedError
+	if errors.As(err, &simErr) {
+		return simErr.Type
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "context_cancelled"
+	}
+	return "unknown"
+}
+
+// IsTransient returns true if err is caused by a temporary condition of the database or
+// the connection to it, which is expected to resolve by itself.
+func IsTransient(err error) bool {
+	for _, target := range []error{ErrTimeout, ErrConnection, ErrPoolExhausted, ErrOverloaded} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRetryable returns true if the failed operation can safely be retried. Besides transient
+// errors this covers deadlocks and serialization failures, for which the whole transaction
+// has to be retried. Context errors are never retryable.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return IsTransient(err) || errors.Is(err, ErrDeadlock) || errors.Is(err, ErrSerialization)
+}
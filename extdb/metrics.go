@@ -1,10 +1,29 @@
 package extdb
 
 import (
+	"database/sql"
+	"sync/atomic"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Names of the database metrics, e.g. for generating rules and dashboards.
+const (
+	MetricQueryDuration             = "db_query_duration_seconds"
+	MetricQueriesTotal              = "db_queries_total"
+	MetricQueryErrorsTotal          = "db_query_errors_total"
+	MetricInflightQueries           = "db_inflight_queries"
+	MetricRowsAffected              = "db_rows_affected"
+	MetricConnectionPool            = "db_connection_pool"
+	MetricConnectionAcquireDuration = "db_connection_acquire_duration_seconds"
+	MetricConnectionsClosedTotal    = "db_connections_closed_total"
+	MetricTransactionsTotal         = "db_transactions_total"
+	MetricTransactionDuration       = "db_transaction_duration_seconds"
+	MetricTransactionStatements     = "db_transaction_statements"
+	MetricState                     = "db_state"
+)
+
 // Metrics holds database operation metrics.
 type Metrics struct {
 	queryDuration   *prometheus.HistogramVec
@@ -13,6 +32,16 @@ type Metrics struct {
 	inflightQueries *prometheus.GaugeVec
 	rowsAffected    *prometheus.HistogramVec
 	connectionPool  *prometheus.GaugeVec
+	connAcquire     *prometheus.HistogramVec
+	connsClosed     *prometheus.CounterVec
+	txTotal         *prometheus.CounterVec
+	txDuration      *prometheus.HistogramVec
+	txStatements    *prometheus.HistogramVec
+
+	// state returns the current database state for db_state, healthy if unset.
+	state atomic.Pointer[func() string]
+	// db is read for db_connection_pool on every collection, if set.
+	db atomic.Pointer[sql.DB]
 }
 
 // NewMetrics creates a new instance of database Metrics.
@@ -25,11 +54,10 @@ func NewMetrics(reg prometheus.Registerer, durationBuckets []float64) *Metrics {
 	const maxBucketNumber = 256
 	const bucketFactor = 1.1
 
-	return &Metrics{
+	m := &Metrics{
 		queryDuration: promauto.With(reg).NewHistogramVec(
 			prometheus.HistogramOpts{
-				Subsystem:                      "db",
-				Name:                           "query_duration_seconds",
+				Name:                           MetricQueryDuration,
 				Help:                           "Histogram of database query durations.",
 				Buckets:                        durationBuckets,
 				NativeHistogramBucketFactor:    bucketFactor,
@@ -40,35 +68,31 @@ func NewMetrics(reg prometheus.Registerer, durationBuckets []float64) *Metrics {
 
 		queriesTotal: promauto.With(reg).NewCounterVec(
 			prometheus.CounterOpts{
-				Subsystem: "db",
-				Name:      "queries_total",
-				Help:      "Total number of database queries.",
+				Name: MetricQueriesTotal,
+				Help: "Total number of database queries.",
 			},
 			[]string{"operation", "table", "status"},
 		),
 
 		queryErrors: promauto.With(reg).NewCounterVec(
 			prometheus.CounterOpts{
-				Subsystem: "db",
-				Name:      "query_errors_total",
-				Help:      "Total number of database query errors.",
+				Name: MetricQueryErrorsTotal,
+				Help: "Total number of database query errors.",
 			},
 			[]string{"operation", "table", "error_type"},
 		),
 
 		inflightQueries: promauto.With(reg).NewGaugeVec(
 			prometheus.GaugeOpts{
-				Subsystem: "db",
-				Name:      "inflight_queries",
-				Help:      "Current number of in-flight database queries.",
+				Name: MetricInflightQueries,
+				Help: "Current number of in-flight database queries.",
 			},
 			[]string{"operation", "table"},
 		),
 
 		rowsAffected: promauto.With(reg).NewHistogramVec(
 			prometheus.HistogramOpts{
-				Subsystem:                      "db",
-				Name:                           "rows_affected",
+				Name:                           MetricRowsAffected,
 				Help:                           "Histogram of rows affected by database operations.",
 				Buckets:                        prometheus.ExponentialBuckets(1, 2, 12),
 				NativeHistogramBucketFactor:    bucketFactor,
@@ -77,15 +101,96 @@ func NewMetrics(reg prometheus.Registerer, durationBuckets []float64) *Metrics {
 			[]string{"operation", "table"},
 		),
 
-		connectionPool: promauto.With(reg).NewGaugeVec(
+		connectionPool: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
-				Subsystem: "db",
-				Name:      "connection_pool",
-				Help:      "Database connection pool statistics.",
+				Name: MetricConnectionPool,
+				Help: "Database connection pool statistics.",
+			},
+			[]string{"state"}, // open, idle, in_use, max, waiting
+		),
+
+		connAcquire: promauto.With(reg).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:                           MetricConnectionAcquireDuration,
+				Help:                           "Histogram of time spent waiting to acquire a connection from the pool.",
+				Buckets:                        durationBuckets,
+				NativeHistogramBucketFactor:    bucketFactor,
+				NativeHistogramMaxBucketNumber: maxBucketNumber,
+			},
+			[]string{"result"},
+		),
+
+		connsClosed: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: MetricConnectionsClosedTotal,
+				Help: "Total number of pooled database connections closed.",
+			},
+			[]string{"reason"}, // max_idle, max_idle_time, max_lifetime
+		),
+
+		txTotal: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: MetricTransactionsTotal,
+				Help: "Total number of database transactions.",
+			},
+			[]string{"status"}, // committed, rolled_back, aborted
+		),
+
+		txDuration: promauto.With(reg).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:                           MetricTransactionDuration,
+				Help:                           "Histogram of database transaction durations, from begin until commit or rollback.",
+				Buckets:                        durationBuckets,
+				NativeHistogramBucketFactor:    bucketFactor,
+				NativeHistogramMaxBucketNumber: maxBucketNumber,
+			},
+			[]string{"status"},
+		),
+
+		txStatements: promauto.With(reg).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:                           MetricTransactionStatements,
+				Help:                           "Histogram of statements executed per database transaction.",
+				Buckets:                        prometheus.LinearBuckets(1, 1, 10),
+				NativeHistogramBucketFactor:    bucketFactor,
+				NativeHistogramMaxBucketNumber: maxBucketNumber,
 			},
-			[]string{"state"}, // open, idle, in_use, max
+			[]string{"status"},
 		),
 	}
+
+	// The state is read on scrape, so it is current even without queries.
+	for _, st := range states {
+		promauto.With(reg).NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name:        MetricState,
+				Help:        "Current simulated database state, 1 for the current state and 0 otherwise.",
+				ConstLabels: prometheus.Labels{"state": st},
+			},
+			func() float64 {
+				if m.currentState() == st {
+					return 1
+				}
+				return 0
+			},
+		)
+	}
+	if reg != nil {
+		reg.MustRegister(connectionPoolCollector{m})
+	}
+	return m
+}
+
+// SetStateFunc sets the function reporting the current database state.
+func (m *Metrics) SetStateFunc(state func() string) {
+	m.state.Store(&state)
+}
+
+func (m *Metrics) currentState() string {
+	if f := m.state.Load(); f != nil {
+		return (*f)()
+	}
+	return StateHealthy
 }
 
 // RecordQuery records metrics for a database query.
@@ -121,3 +226,42 @@ func (m *Metrics) SetConnectionPool(open, idle, inUse, maxOpen float64) {
 	m.connectionPool.WithLabelValues("in_use").Set(inUse)
 	m.connectionPool.WithLabelValues("max").Set(maxOpen)
 }
+
+// SetConnectionPoolWaiting sets the number of queries waiting for a connection.
+func (m *Metrics) SetConnectionPoolWaiting(waiting float64) {
+	m.connectionPool.WithLabelValues("waiting").Set(waiting)
+}
+
+// RecordConnAcquire records the time spent acquiring a connection from the pool.
+func (m *Metrics) RecordConnAcquire(result string, duration float64) {
+	m.connAcquire.WithLabelValues(result).Observe(duration)
+}
+
+// RecordConnClosed records a pooled connection being closed.
+func (m *Metrics) RecordConnClosed(reason string) {
+	m.connsClosed.WithLabelValues(reason).Inc()
+}
+
+// RecordTransaction records a finished transaction.
+func (m *Metrics) RecordTransaction(status string, duration float64, statements int) {
+	m.txTotal.WithLabelValues(status).Inc()
+	m.txDuration.WithLabelValues(status).Observe(duration)
+	m.txStatements.WithLabelValues(status).Observe(float64(statements))
+}
+
+// connectionPoolCollector collects the connection_pool gauge, refreshing it from
+// the database/sql stats first if CollectDBStats was called.
+type connectionPoolCollector struct {
+	m *Metrics
+}
+
+func (c connectionPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.m.connectionPool.Describe(ch)
+}
+
+func (c connectionPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	if db := c.m.db.Load(); db != nil {
+		c.m.SetDBStats(db.Stats())
+	}
+	c.m.connectionPool.Collect(ch)
+}
@@ -0,0 +1,185 @@
+package extdb
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PoolOpts configures the simulated connection pool.
+// A zero MaxOpen disables pool simulation, queries then never wait for a connection.
+type PoolOpts struct {
+	// MaxOpen is the maximum number of open connections.
+	MaxOpen int
+
+	// MaxIdle is the maximum number of idle connections kept for reuse.
+	MaxIdle int
+
+	// MaxIdleTime is how long a connection may be idle before it is closed. Zero means no limit.
+	MaxIdleTime time.Duration
+
+	// MaxLifetime is how long a connection may be reused since it was opened. Zero means no limit.
+	MaxLifetime time.Duration
+
+	// AcquireTimeout is how long a query waits for a connection when all are in use,
+	// before failing with ErrPoolExhausted. Zero means waiting until the context is done.
+	AcquireTimeout time.Duration
+
+	// ConnectLatency is added to queries that have to open a new connection.
+	ConnectLatency time.Duration
+}
+
+// conn is a simulated database connection.
+type conn struct {
+	createdAt  time.Time
+	returnedAt time.Time
+	new        bool
+}
+
+// pool simulates a database connection pool similar to database/sql.
+// Expired idle connections are reaped lazily whenever the pool is used.
+type pool struct {
+	opts    PoolOpts
+	metrics *Metrics
+
+	mtx     sync.Mutex
+	open    int
+	idle    []*conn // Most recently returned last.
+	waiters []chan *conn
+}
+
+func newPool(metrics *Metrics, opts PoolOpts) *pool {
+	p := &pool{opts: opts, metrics: metrics}
+	p.updateMetricsLocked()
+	return p
+}
+
+// acquire returns a connection, opening a new one if none is idle and the pool is not full,
+// or waiting for one to be released otherwise.
+func (p *pool) acquire(ctx context.Context) (*conn, error) {
+	start := time.Now()
+
+	p.mtx.Lock()
+	p.reapLocked(start)
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		c.new = false
+		p.updateMetricsLocked()
+		p.mtx.Unlock()
+		p.metrics.RecordConnAcquire("success", time.Since(start).Seconds())
+		return c, nil
+	}
+	if p.open < p.opts.MaxOpen {
+		p.open++
+		p.updateMetricsLocked()
+		p.mtx.Unlock()
+		p.metrics.RecordConnAcquire("success", time.Since(start).Seconds())
+		return &conn{createdAt: time.Now(), new: true}, nil
+	}
+
+	ch := make(chan *conn, 1)
+	p.waiters = append(p.waiters, ch)
+	p.updateMetricsLocked()
+	p.mtx.Unlock()
+
+	var timeout <-chan time.Time
+	if p.opts.AcquireTimeout > 0 {
+		t := time.NewTimer(p.opts.AcquireTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case c := <-ch:
+		p.metrics.RecordConnAcquire("success", time.Since(start).Seconds())
+		return c, nil
+	case <-ctx.Done():
+		p.abandon(ch)
+		p.metrics.RecordConnAcquire("context_cancelled", time.Since(start).Seconds())
+		return nil, newSimulatedError("context_cancelled", ctx.Err())
+	case <-timeout:
+		p.abandon(ch)
+		p.metrics.RecordConnAcquire("pool_exhausted", time.Since(start).Seconds())
+		return nil, newSimulatedError("pool_exhausted", nil)
+	}
+}
+
+// abandon removes a waiter that gave up. A connection handed over concurrently is released again.
+func (p *pool) abandon(ch chan *conn) {
+	p.mtx.Lock()
+	for i, w := range p.waiters {
+		if w == ch {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			p.updateMetricsLocked()
+			p.mtx.Unlock()
+			return
+		}
+	}
+	p.mtx.Unlock()
+
+	// Not waiting anymore, so a connection was already handed over.
+	p.release(<-ch)
+}
+
+// release returns a connection to the pool, handing it to the longest waiting query if any.
+func (p *pool) release(c *conn) {
+	now := time.Now()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	c.new = false
+	if p.opts.MaxLifetime > 0 && now.Sub(c.createdAt) >= p.opts.MaxLifetime {
+		p.metrics.RecordConnClosed("max_lifetime")
+		if len(p.waiters) == 0 {
+			p.open--
+			p.updateMetricsLocked()
+			return
+		}
+		// Replace the expired connection for the waiter.
+		c = &conn{createdAt: now, new: true}
+	}
+
+	if len(p.waiters) > 0 {
+		ch := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		ch <- c
+		p.updateMetricsLocked()
+		return
+	}
+
+	if len(p.idle) >= p.opts.MaxIdle {
+		p.metrics.RecordConnClosed("max_idle")
+		p.open--
+		p.updateMetricsLocked()
+		return
+	}
+	c.returnedAt = now
+	p.idle = append(p.idle, c)
+	p.reapLocked(now)
+	p.updateMetricsLocked()
+}
+
+// reapLocked closes idle connections that exceeded their idle time or lifetime.
+func (p *pool) reapLocked(now time.Time) {
+	kept := p.idle[:0]
+	for _, c := range p.idle {
+		switch {
+		case p.opts.MaxIdleTime > 0 && now.Sub(c.returnedAt) >= p.opts.MaxIdleTime:
+			p.metrics.RecordConnClosed("max_idle_time")
+			p.open--
+		case p.opts.MaxLifetime > 0 && now.Sub(c.createdAt) >= p.opts.MaxLifetime:
+			p.metrics.RecordConnClosed("max_lifetime")
+			p.open--
+		default:
+			kept = append(kept, c)
+		}
+	}
+	p.idle = kept
+}
+
+func (p *pool) updateMetricsLocked() {
+	p.metrics.SetConnectionPool(float64(p.open), float64(len(p.idle)), float64(p.open-len(p.idle)), float64(p.opts.MaxOpen))
+	p.metrics.SetConnectionPoolWaiting(float64(len(p.waiters)))
+}
@@ -0,0 +1,128 @@
+package extdb
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saswatamcode/pingpong/extrand"
+)
+
+// ProfileOpts configures the simulated behavior of queries on a table or of a single operation
+// on a table. Empty fields fall back to the simulator-wide options, see SimulatorOpts.
+type ProfileOpts struct {
+	// Latency in format: <probability>%<duration>,<probability>%<duration>...
+	Latency string `yaml:"latency"`
+
+	// SuccessProb is the probability (in %) of a successful query (0-100).
+	SuccessProb *float64 `yaml:"success_prob"`
+
+	// ErrorTypes in format: <probability>%<error_type>,<probability>%<error_type>...
+	ErrorTypes string `yaml:"error_types"`
+
+	// RowsAffected in format: <probability>%<rows>,<probability>%<rows>...
+	RowsAffected string `yaml:"rows_affected"`
+}
+
+// profile is the parsed form of ProfileOpts.
+type profile struct {
+	latency     *extrand.WeightedChoice[time.Duration]
+	errors      *extrand.WeightedChoice[string]
+	successProb float64
+	rows        *extrand.WeightedChoice[int] // Nil means a random number of rows between 1 and 100.
+}
+
+func newProfile(opts ProfileOpts) (*profile, error) {
+	latency, err := extrand.NewWeightedChoice(opts.Latency, time.ParseDuration)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing latency")
+	}
+
+	errorTypes := opts.ErrorTypes
+	if errorTypes == "" {
+		errorTypes = "100%generic"
+	}
+	errs, err := extrand.NewWeightedChoice(errorTypes, parseErrorType)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing error types")
+	}
+
+	p := &profile{latency: latency, errors: errs, successProb: *opts.SuccessProb}
+	if opts.RowsAffected != "" {
+		if p.rows, err = extrand.NewWeightedChoice(opts.RowsAffected, parseRows); err != nil {
+			return nil, errors.Wrap(err, "parsing rows affected")
+		}
+	}
+	return p, nil
+}
+
+// with returns a copy of the profile with the fields set in opts overridden.
+func (p *profile) with(opts ProfileOpts) (*profile, error) {
+	o := *p
+
+	var err error
+	if opts.Latency != "" {
+		if o.latency, err = extrand.NewWeightedChoice(opts.Latency, time.ParseDuration); err != nil {
+			return nil, errors.Wrap(err, "parsing latency")
+		}
+	}
+	if opts.SuccessProb != nil {
+		o.successProb = *opts.SuccessProb
+	}
+	if opts.ErrorTypes != "" {
+		if o.errors, err = extrand.NewWeightedChoice(opts.ErrorTypes, parseErrorType); err != nil {
+			return nil, errors.Wrap(err, "parsing error types")
+		}
+	}
+	if opts.RowsAffected != "" {
+		if o.rows, err = extrand.NewWeightedChoice(opts.RowsAffected, parseRows); err != nil {
+			return nil, errors.Wrap(err, "parsing rows affected")
+		}
+	}
+	return &o, nil
+}
+
+func (p *profile) pickLatency() time.Duration {
+	latency, _ := p.latency.Pick()
+	return latency
+}
+
+func (p *profile) pickErrorType() string {
+	errorType, _ := p.errors.Pick()
+	return errorType
+}
+
+func (p *profile) rowsAffected() int {
+	if p.rows == nil {
+		return rand.Intn(100) + 1
+	}
+	rows, _ := p.rows.Pick()
+	return rows
+}
+
+func parseRows(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("parse rows %v as non-negative integer", s)
+	}
+	return n, nil
+}
+
+// profile returns the most specific profile for the operation on the table.
+func (s *Simulator) profile(operation, table string) *profile {
+	if p, ok := s.profiles[table+"."+operation]; ok {
+		return p
+	}
+	if p, ok := s.profiles[table]; ok {
+		return p
+	}
+	return s.defaults
+}
+
+func parseErrorType(s string) (string, error) {
+	if s == "" {
+		return "", errors.New("empty error type")
+	}
+	return s, nil
+}
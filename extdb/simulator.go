@@ -2,15 +2,12 @@ package extdb
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 	"math/rand"
-	"sort"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/saswatamcode/pingpong/extgate"
 )
 
 // SimulatorOpts configures the database simulator behavior.
@@ -27,6 +24,29 @@ type SimulatorOpts struct {
 	// e.g., "50%timeout,30%connection,20%deadlock"
 	// If not set, defaults to "100%generic"
 	ErrorTypes string
+
+	// Pool configures the simulated connection pool. Disabled if Pool.MaxOpen is zero.
+	Pool PoolOpts
+
+	// TxConflictProb is the probability (in %) that committing a transaction fails
+	// with a serialization failure, aborting it.
+	TxConflictProb float64
+
+	// RowsAffected is the encoded distribution of rows affected by successful queries in format:
+	// <probability>%<rows>,<probability>%<rows>... If not set, a random number between 1 and 100.
+	RowsAffected string
+
+	// Profiles override the options above for specific tables and operations. They are keyed by
+	// "<table>.<operation>", e.g. "orders.insert", or "<table>" for all operations on a table.
+	// The most specific profile wins, unset profile fields fall back to the options above.
+	Profiles map[string]ProfileOpts
+
+	// State configures correlated failures like outages and degradation windows.
+	State StateOpts
+
+	// Gate optionally models the capacity of the database server. Queries wait for a free
+	// worker and fail with an "overloaded" error if the gate rejects them.
+	Gate *extgate.Gate
 }
 
 // DefaultSimulatorOpts returns default simulator options.
@@ -35,91 +55,133 @@ func DefaultSimulatorOpts() SimulatorOpts {
 		Latency:     "90%10ms,10%50ms",
 		SuccessProb: 95,
 		ErrorTypes:  "50%timeout,30%connection,20%deadlock",
+		State: StateOpts{
+			DegradedLatencyFactor: 5,
+			DegradedSuccessProb:   50,
+		},
+		Pool: PoolOpts{
+			MaxIdle:        2,
+			AcquireTimeout: time.Second,
+			ConnectLatency: 5 * time.Millisecond,
+		},
 	}
 }
 
 // Simulator simulates database operations with configurable latency and errors.
 type Simulator struct {
 	metrics      *Metrics
-	latDecider   *latencyDecider
-	errorDecider *errorDecider
-	successProb  float64
+	defaults     *profile
+	profiles     map[string]*profile
+	conflictProb float64
+	state        *stateMachine
+	pool         *pool
+	gate         *extgate.Gate
 }
 
 // NewSimulator creates a new database simulator.
 func NewSimulator(metrics *Metrics, opts SimulatorOpts) (*Simulator, error) {
-	latDecider, err := newLatencyDecider(opts.Latency)
+	defaults, err := newProfile(ProfileOpts{
+		Latency:      opts.Latency,
+		SuccessProb:  &opts.SuccessProb,
+		ErrorTypes:   opts.ErrorTypes,
+		RowsAffected: opts.RowsAffected,
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing latency")
+		return nil, err
 	}
 
-	errorTypes := opts.ErrorTypes
-	if errorTypes == "" {
-		errorTypes = "100%generic"
+	s := &Simulator{
+		metrics:      metrics,
+		defaults:     defaults,
+		profiles:     make(map[string]*profile, len(opts.Profiles)),
+		conflictProb: opts.TxConflictProb,
+		gate:         opts.Gate,
 	}
-	errorDecider, err := newErrorDecider(errorTypes)
-	if err != nil {
-		return nil, errors.Wrap(err, "parsing error types")
+	if s.state, err = newStateMachine(opts.State); err != nil {
+		return nil, errors.Wrap(err, "parsing db state")
 	}
-
-	return &Simulator{
-		metrics:      metrics,
-		latDecider:   latDecider,
-		errorDecider: errorDecider,
-		successProb:  opts.SuccessProb,
-	}, nil
+	metrics.SetStateFunc(s.State)
+	for key, p := range opts.Profiles {
+		if s.profiles[key], err = defaults.with(p); err != nil {
+			return nil, errors.Wrapf(err, "profile %q", key)
+		}
+	}
+	if opts.Pool.MaxOpen > 0 {
+		if opts.Pool.MaxIdle > opts.Pool.MaxOpen {
+			return nil, errors.Errorf("max idle connections %v cannot exceed max open connections %v", opts.Pool.MaxIdle, opts.Pool.MaxOpen)
+		}
+		s.pool = newPool(metrics, opts.Pool)
+	}
+	return s, nil
 }
 
 // QueryResult represents the result of a simulated query.
 type QueryResult struct {
-	Success      bool
-	ErrorType    string
 	Duration     time.Duration
 	RowsAffected int
 }
 
 // SimulateQuery simulates a database query with the configured latency and error rates.
-// It records metrics and returns the result.
-func (s *Simulator) SimulateQuery(ctx context.Context, operation, table string) QueryResult {
+// It records metrics and returns the result. Failed queries return a SimulatedError
+// wrapping one of the sentinel errors, or the context error if the context was done.
+func (s *Simulator) SimulateQuery(ctx context.Context, operation, table string) (QueryResult, error) {
 	s.metrics.IncInflight(operation, table)
 	defer s.metrics.DecInflight(operation, table)
 
 	start := time.Now()
 
 	// Add latency
-	latency := s.latDecider.GetLatency()
+	latency := s.profile(operation, table).pickLatency()
 
-	// Check if context is already cancelled
-	select {
-	case <-ctx.Done():
-		s.metrics.RecordError(operation, table, "context_cancelled")
-		s.metrics.RecordQuery(operation, table, "error", time.Since(start).Seconds())
-		return QueryResult{
-			Success:   false,
-			ErrorType: "context_cancelled",
-			Duration:  time.Since(start),
+	// Wait for a pooled connection, opening a new one costs extra latency.
+	if s.pool != nil {
+		c, err := s.pool.acquire(ctx)
+		if err != nil {
+			return s.failQuery(operation, table, err, start)
 		}
-	default:
+		defer s.pool.release(c)
+		if c.new {
+			latency += s.pool.opts.ConnectLatency
+		}
+	}
+
+	return s.execute(ctx, operation, table, start, latency)
+}
+
+// execute simulates executing a statement on an acquired connection, taking the given latency.
+func (s *Simulator) execute(ctx context.Context, operation, table string, start time.Time, latency time.Duration) (QueryResult, error) {
+	p := s.profile(operation, table)
+	successProb := p.successProb
+
+	switch s.State() {
+	case StateDown:
+		return s.failQuery(operation, table, newSimulatedError("connection", nil), start)
+	case StateDegraded:
+		latency = time.Duration(float64(latency) * s.state.opts.DegradedLatencyFactor)
+		successProb = min(successProb, s.state.opts.DegradedSuccessProb)
+	}
+
+	// Wait for a free database worker, queueing adds latency under load.
+	if err := s.gate.Start(ctx); err != nil {
+		if ctx.Err() != nil {
+			return s.failQuery(operation, table, newSimulatedError("context_cancelled", ctx.Err()), start)
+		}
+		return s.failQuery(operation, table, newSimulatedError("overloaded", err), start)
 	}
+	defer s.gate.Done()
 
 	// Wait for latency or context cancellation
 	select {
 	case <-ctx.Done():
-		s.metrics.RecordError(operation, table, "context_cancelled")
-		s.metrics.RecordQuery(operation, table, "error", time.Since(start).Seconds())
-		return QueryResult{
-			Success:   false,
-			ErrorType: "context_cancelled",
-			Duration:  time.Since(start),
-		}
+		return s.failQuery(operation, table, newSimulatedError("context_cancelled", ctx.Err()), start)
 	case <-time.After(latency):
 	}
 
 	duration := time.Since(start)
 
 	// Determine success or failure
-	if rand.Float64()*100 <= s.successProb {
-		rowsAffected := rand.Intn(100) + 1 // Random rows affected between 1-100
+	if rand.Float64()*100 <= successProb {
+		rowsAffected := p.rowsAffected()
 		s.metrics.RecordQuery(operation, table, "success", duration.Seconds())
 		s.metrics.RecordRowsAffected(operation, table, float64(rowsAffected))
 
@@ -131,14 +193,19 @@ func (s *Simulator) SimulateQuery(ctx context.Context, operation, table string)
 		)
 
 		return QueryResult{
-			Success:      true,
 			Duration:     duration,
 			RowsAffected: rowsAffected,
-		}
+		}, nil
 	}
 
 	// Query failed
-	errorType := s.errorDecider.GetErrorType()
+	return s.failQuery(operation, table, newSimulatedError(p.pickErrorType(), nil), start)
+}
+
+// failQuery records a failed query and returns the error.
+func (s *Simulator) failQuery(operation, table string, err error, start time.Time) (QueryResult, error) {
+	duration := time.Since(start)
+	errorType := ErrorType(err)
 	s.metrics.RecordQuery(operation, table, "error", duration.Seconds())
 	s.metrics.RecordError(operation, table, errorType)
 
@@ -149,130 +216,25 @@ func (s *Simulator) SimulateQuery(ctx context.Context, operation, table string)
 		"error_type", errorType,
 	)
 
-	return QueryResult{
-		Success:   false,
-		ErrorType: errorType,
-		Duration:  duration,
-	}
+	return QueryResult{Duration: duration}, err
 }
 
 // SimulateSelect simulates a SELECT query.
-func (s *Simulator) SimulateSelect(ctx context.Context, table string) QueryResult {
+func (s *Simulator) SimulateSelect(ctx context.Context, table string) (QueryResult, error) {
 	return s.SimulateQuery(ctx, "select", table)
 }
 
 // SimulateInsert simulates an INSERT query.
-func (s *Simulator) SimulateInsert(ctx context.Context, table string) QueryResult {
+func (s *Simulator) SimulateInsert(ctx context.Context, table string) (QueryResult, error) {
 	return s.SimulateQuery(ctx, "insert", table)
 }
 
 // SimulateUpdate simulates an UPDATE query.
-func (s *Simulator) SimulateUpdate(ctx context.Context, table string) QueryResult {
+func (s *Simulator) SimulateUpdate(ctx context.Context, table string) (QueryResult, error) {
 	return s.SimulateQuery(ctx, "update", table)
 }
 
 // SimulateDelete simulates a DELETE query.
-func (s *Simulator) SimulateDelete(ctx context.Context, table string) QueryResult {
+func (s *Simulator) SimulateDelete(ctx context.Context, table string) (QueryResult, error) {
 	return s.SimulateQuery(ctx, "delete", table)
 }
-
-// latencyDecider determines latency based on configured probabilities.
-type latencyDecider struct {
-	latencies     []time.Duration
-	probabilities []float64
-}
-
-func newLatencyDecider(encodedLatencies string) (*latencyDecider, error) {
-	l := latencyDecider{}
-
-	s := strings.Split(encodedLatencies, ",")
-	sort.Strings(s)
-
-	cumulativeProb := 0.0
-	for _, e := range s {
-		entry := strings.Split(e, "%")
-		if len(entry) != 2 {
-			return nil, errors.Errorf("invalid latency input %v", encodedLatencies)
-		}
-		f, err := strconv.ParseFloat(entry[0], 64)
-		if err != nil {
-			return nil, errors.Wrapf(err, "parse probability %v as float", entry[0])
-		}
-		cumulativeProb += f
-		l.probabilities = append(l.probabilities, f)
-
-		d, err := time.ParseDuration(entry[1])
-		if err != nil {
-			return nil, errors.Wrapf(err, "parse latency %v as duration", entry[1])
-		}
-		l.latencies = append(l.latencies, d)
-	}
-	if cumulativeProb != 100 {
-		return nil, errors.Errorf("overall probability has to equal 100. Parsed input equals to %v", cumulativeProb)
-	}
-	slog.Info("db latency decider created", "latencies", l.latencies, "probabilities", l.probabilities)
-	return &l, nil
-}
-
-func (l *latencyDecider) GetLatency() time.Duration {
-	n := rand.Float64() * 100
-	for i, p := range l.probabilities {
-		if n <= p {
-			return l.latencies[i]
-		}
-	}
-	return l.latencies[len(l.latencies)-1]
-}
-
-// errorDecider determines error type based on configured probabilities.
-type errorDecider struct {
-	errorTypes    []string
-	probabilities []float64
-}
-
-func newErrorDecider(encodedErrors string) (*errorDecider, error) {
-	e := errorDecider{}
-
-	s := strings.Split(encodedErrors, ",")
-	sort.Strings(s)
-
-	cumulativeProb := 0.0
-	for _, entry := range s {
-		parts := strings.Split(entry, "%")
-		if len(parts) != 2 {
-			return nil, errors.Errorf("invalid error type input %v", encodedErrors)
-		}
-		f, err := strconv.ParseFloat(parts[0], 64)
-		if err != nil {
-			return nil, errors.Wrapf(err, "parse probability %v as float", parts[0])
-		}
-		cumulativeProb += f
-		e.probabilities = append(e.probabilities, f)
-		e.errorTypes = append(e.errorTypes, parts[1])
-	}
-	if cumulativeProb != 100 {
-		return nil, errors.Errorf("overall error probability has to equal 100. Parsed input equals to %v", cumulativeProb)
-	}
-	slog.Info("db error decider created", "error_types", e.errorTypes, "probabilities", e.probabilities)
-	return &e, nil
-}
-
-func (e *errorDecider) GetErrorType() string {
-	n := rand.Float64() * 100
-	for i, p := range e.probabilities {
-		if n <= p {
-			return e.errorTypes[i]
-		}
-	}
-	return e.errorTypes[len(e.errorTypes)-1]
-}
-
-// SimulatedError represents a simulated database error.
-type SimulatedError struct {
-	Type    string
-	Message string
-}
-
-func (e SimulatedError) Error() string {
-	return fmt.Sprintf("simulated db error [%s]: %s", e.Type, e.Message)
-}
@@ -0,0 +1,209 @@
+package extdb
+
+import (
+	"log/slog"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Simulated database states, exposed by the db_state gauge.
+const (
+	// StateHealthy serves queries as configured.
+	StateHealthy = "healthy"
+	// StateDegraded serves queries with elevated latency and error rate, e.g. during a failover.
+	StateDegraded = "degraded"
+	// StateDown fails all queries with connection errors.
+	StateDown = "down"
+)
+
+var states = []string{StateHealthy, StateDegraded, StateDown}
+
+// StateOpts configures correlated failures of the simulated database. Without transitions
+// and outages the database stays healthy.
+type StateOpts struct {
+	// Transitions of the database state as a continuous-time Markov chain, in format:
+	// <from>:<to>=<mean duration>,... e.g. "healthy:down=10m,down:healthy=30s" causes outages
+	// of 30s on average, every 10m on average. Transitions out of a state race each other.
+	Transitions string
+
+	// Outages are scheduled state windows relative to the simulator start, in format:
+	// <state>@<start>+<duration>[/<every>],... e.g. "down@1m+30s/5m" takes the database down for
+	// 30s after 1m, and again every 5m. Outages override the state of the Markov chain.
+	Outages string
+
+	// DegradedLatencyFactor multiplies query latency while degraded.
+	DegradedLatencyFactor float64
+
+	// DegradedSuccessProb caps the probability (in %) of a successful query while degraded.
+	DegradedSuccessProb float64
+}
+
+type transition struct {
+	to   string
+	mean time.Duration
+}
+
+type outage struct {
+	state                  string
+	start, duration, every time.Duration
+}
+
+// active reports whether the outage is active elapsed time after the simulator start.
+func (o outage) active(elapsed time.Duration) bool {
+	if elapsed < o.start {
+		return false
+	}
+	if o.every == 0 {
+		return elapsed < o.start+o.duration
+	}
+	return (elapsed-o.start)%o.every < o.duration
+}
+
+// stateMachine tracks the simulated database state. The Markov chain is advanced lazily
+// whenever the state is read. A nil stateMachine is always healthy.
+type stateMachine struct {
+	opts        StateOpts
+	transitions map[string][]transition
+	outages     []outage
+	start       time.Time
+
+	mtx       sync.Mutex
+	chain     string    // State of the Markov chain.
+	next      time.Time // Time of the next transition of the chain, zero if none.
+	nextState string
+	reported  string // Last returned state, to log changes.
+}
+
+func newStateMachine(opts StateOpts) (*stateMachine, error) {
+	if opts.Transitions == "" && opts.Outages == "" {
+		return nil, nil
+	}
+
+	m := &stateMachine{
+		opts:        opts,
+		transitions: map[string][]transition{},
+		start:       time.Now(),
+		chain:       StateHealthy,
+		reported:    StateHealthy,
+	}
+	if opts.Transitions != "" {
+		for _, e := range strings.Split(opts.Transitions, ",") {
+			fromTo, mean, ok := strings.Cut(e, "=")
+			from, to, ok2 := strings.Cut(fromTo, ":")
+			if !ok || !ok2 {
+				return nil, errors.Errorf("invalid state transition %q, expected <from>:<to>=<mean duration>", e)
+			}
+			if err := validState(from); err != nil {
+				return nil, err
+			}
+			if err := validState(to); err != nil {
+				return nil, err
+			}
+			d, err := time.ParseDuration(mean)
+			if err != nil || d <= 0 {
+				return nil, errors.Errorf("parse mean duration %v of state transition %q as positive duration", mean, e)
+			}
+			m.transitions[from] = append(m.transitions[from], transition{to: to, mean: d})
+		}
+	}
+	if opts.Outages != "" {
+		for _, e := range strings.Split(opts.Outages, ",") {
+			o, err := parseOutage(e)
+			if err != nil {
+				return nil, err
+			}
+			m.outages = append(m.outages, o)
+		}
+	}
+	m.scheduleLocked(m.start)
+	slog.Info("db state machine created", "transitions", opts.Transitions, "outages", opts.Outages)
+	return m, nil
+}
+
+func parseOutage(e string) (outage, error) {
+	state, window, ok := strings.Cut(e, "@")
+	start, rest, ok2 := strings.Cut(window, "+")
+	if !ok || !ok2 {
+		return outage{}, errors.Errorf("invalid outage %q, expected <state>@<start>+<duration>[/<every>]", e)
+	}
+	if err := validState(state); err != nil {
+		return outage{}, err
+	}
+	duration, every, _ := strings.Cut(rest, "/")
+
+	o := outage{state: state}
+	var err error
+	if o.start, err = time.ParseDuration(start); err != nil {
+		return outage{}, errors.Wrapf(err, "parse start of outage %q", e)
+	}
+	if o.duration, err = time.ParseDuration(duration); err != nil {
+		return outage{}, errors.Wrapf(err, "parse duration of outage %q", e)
+	}
+	if every != "" {
+		if o.every, err = time.ParseDuration(every); err != nil {
+			return outage{}, errors.Wrapf(err, "parse period of outage %q", e)
+		}
+		if o.every < o.duration {
+			return outage{}, errors.Errorf("period of outage %q has to be at least its duration", e)
+		}
+	}
+	return o, nil
+}
+
+func validState(s string) error {
+	for _, st := range states {
+		if s == st {
+			return nil
+		}
+	}
+	return errors.Errorf("unknown db state %q, expected one of: %v", s, states)
+}
+
+// scheduleLocked samples the next transition of the chain, entered at the given time.
+func (m *stateMachine) scheduleLocked(entered time.Time) {
+	m.next = time.Time{}
+	for _, t := range m.transitions[m.chain] {
+		at := entered.Add(time.Duration(rand.ExpFloat64() * float64(t.mean)))
+		if m.next.IsZero() || at.Before(m.next) {
+			m.next, m.nextState = at, t.to
+		}
+	}
+}
+
+// current returns the state at the given time.
+func (m *stateMachine) current(now time.Time) string {
+	if m == nil {
+		return StateHealthy
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for !m.next.IsZero() && !now.Before(m.next) {
+		entered := m.next
+		m.chain = m.nextState
+		m.scheduleLocked(entered)
+	}
+
+	state := m.chain
+	for _, o := range m.outages {
+		if o.active(now.Sub(m.start)) {
+			state = o.state
+			break
+		}
+	}
+	if state != m.reported {
+		slog.Warn("simulated db state changed", "from", m.reported, "to", state)
+		m.reported = state
+	}
+	return state
+}
+
+// State returns the current simulated database state.
+func (s *Simulator) State() string {
+	return s.state.current(time.Now())
+}
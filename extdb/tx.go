@@ -0,0 +1,146 @@
+package extdb
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Transaction statuses recorded in db_transactions_total.
+const (
+	txCommitted  = "committed"
+	txRolledBack = "rolled_back"
+	txAborted    = "aborted"
+)
+
+// Tx is a simulated transaction. It holds a pooled connection from BeginTx until it is
+// committed or rolled back. Any failing statement aborts the whole transaction, like in
+// PostgreSQL, after which only Rollback succeeds. A Tx must not be used concurrently.
+type Tx struct {
+	s     *Simulator
+	conn  *conn
+	start time.Time
+
+	// connectLatency is added to the first statement if a new connection had to be opened.
+	connectLatency time.Duration
+	statements     int
+	abortedBy      error
+	done           bool
+}
+
+// BeginTx starts a simulated transaction, acquiring a connection from the pool if enabled.
+func (s *Simulator) BeginTx(ctx context.Context) (*Tx, error) {
+	tx := &Tx{s: s, start: time.Now()}
+
+	if s.pool != nil {
+		c, err := s.pool.acquire(ctx)
+		if err != nil {
+			s.metrics.RecordError("begin", "", ErrorType(err))
+			s.metrics.RecordTransaction(txAborted, time.Since(tx.start).Seconds(), 0)
+			return nil, err
+		}
+		tx.conn = c
+		if c.new {
+			tx.connectLatency = s.pool.opts.ConnectLatency
+		}
+	}
+	return tx, nil
+}
+
+// Exec simulates a statement within the transaction. Its latency accumulates into the
+// transaction duration. If it fails, the transaction is aborted and further statements
+// fail with ErrTxAborted.
+func (tx *Tx) Exec(ctx context.Context, operation, table string) (QueryResult, error) {
+	if tx.done {
+		return QueryResult{}, newSimulatedError("tx_done", nil)
+	}
+	if tx.abortedBy != nil {
+		return QueryResult{}, newSimulatedError("tx_aborted", nil)
+	}
+
+	tx.s.metrics.IncInflight(operation, table)
+	defer tx.s.metrics.DecInflight(operation, table)
+
+	tx.statements++
+	latency := tx.s.profile(operation, table).pickLatency() + tx.connectLatency
+	tx.connectLatency = 0
+
+	res, err := tx.s.execute(ctx, operation, table, time.Now(), latency)
+	if err != nil {
+		tx.abortedBy = err
+	}
+	return res, err
+}
+
+// Commit simulates committing the transaction. It fails with ErrTxAborted if the transaction
+// was aborted or, with the configured conflict probability, with ErrSerialization.
+func (tx *Tx) Commit(ctx context.Context) error {
+	if tx.done {
+		return newSimulatedError("tx_done", nil)
+	}
+
+	if tx.abortedBy != nil {
+		tx.finish(txAborted)
+		return newSimulatedError("tx_aborted", nil)
+	}
+
+	// Commit takes a round trip to the database, unless it is down.
+	if tx.s.State() == StateDown {
+		tx.abortedBy = newSimulatedError("connection", nil)
+	} else {
+		select {
+		case <-ctx.Done():
+			tx.abortedBy = newSimulatedError("context_cancelled", ctx.Err())
+		case <-time.After(tx.s.defaults.pickLatency()):
+			if rand.Float64()*100 < tx.s.conflictProb {
+				tx.abortedBy = newSimulatedError("serialization_failure", nil)
+			}
+		}
+	}
+
+	if tx.abortedBy != nil {
+		tx.s.metrics.RecordError("commit", "", ErrorType(tx.abortedBy))
+		tx.finish(txAborted)
+		return tx.abortedBy
+	}
+	tx.finish(txCommitted)
+	return nil
+}
+
+// Rollback rolls the transaction back. It is a no-op if the transaction is already done,
+// so it can be deferred right after BeginTx.
+func (tx *Tx) Rollback() {
+	if tx.done {
+		return
+	}
+	if tx.abortedBy != nil {
+		tx.finish(txAborted)
+		return
+	}
+	tx.finish(txRolledBack)
+}
+
+// finish releases the connection and records the transaction outcome.
+func (tx *Tx) finish(status string) {
+	tx.done = true
+	if tx.conn != nil {
+		tx.s.pool.release(tx.conn)
+	}
+	duration := time.Since(tx.start)
+	tx.s.metrics.RecordTransaction(status, duration.Seconds(), tx.statements)
+
+	if status == txAborted {
+		slog.Warn("simulated db transaction aborted",
+			"duration", duration,
+			"statements", tx.statements,
+			"error_type", ErrorType(tx.abortedBy),
+		)
+		return
+	}
+	slog.Debug("simulated db transaction finished",
+		"status", status,
+		"duration", duration,
+		"statements", tx.statements,
+	)
+}
@@ -0,0 +1,149 @@
+// Package extgate models the capacity of a simulated service as a fixed number of
+// workers with a bounded queue in front of them (M/M/c with a finite queue).
+// As concurrency approaches capacity, requests spend longer waiting in the queue
+// and are eventually rejected, so latency and errors rise with load.
+package extgate
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// ErrQueueFull is returned when all workers are busy and the queue is full.
+	ErrQueueFull = errors.New("queue full")
+	// ErrQueueTimeout is returned when a request waited in the queue for too long.
+	ErrQueueTimeout = errors.New("queue timeout")
+)
+
+// Opts configures a Gate.
+type Opts struct {
+	// Workers is the number of requests served concurrently. Zero disables the gate.
+	Workers int
+
+	// QueueSize is the number of requests that may wait for a worker.
+	// Requests beyond it are rejected with ErrQueueFull.
+	QueueSize int
+
+	// QueueTimeout is how long a request may wait for a worker before being rejected
+	// with ErrQueueTimeout. Zero means waiting until the context is done.
+	QueueTimeout time.Duration
+}
+
+// Gate limits concurrency to a number of workers, queueing requests beyond it.
+type Gate struct {
+	opts    Opts
+	workers chan struct{}
+	queued  atomic.Int64
+
+	busy      prometheus.Gauge
+	queueLen  prometheus.Gauge
+	queueWait prometheus.Histogram
+	rejected  *prometheus.CounterVec
+}
+
+// New creates a Gate and registers its metrics with the given subsystem, e.g. "pong" results
+// in pong_gate_workers_busy. It returns nil if opts.Workers is zero, a nil Gate admits everything.
+func New(reg prometheus.Registerer, subsystem string, opts Opts) *Gate {
+	if opts.Workers <= 0 {
+		return nil
+	}
+
+	g := &Gate{
+		opts:    opts,
+		workers: make(chan struct{}, opts.Workers),
+
+		busy: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "gate_workers_busy",
+			Help:      "Current number of busy workers.",
+		}),
+		queueLen: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Subsystem: subsystem,
+			Name:      "gate_queue_length",
+			Help:      "Current number of requests waiting for a worker.",
+		}),
+		queueWait: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Subsystem:                      subsystem,
+			Name:                           "gate_queue_wait_seconds",
+			Help:                           "Histogram of time requests spent waiting for a worker.",
+			Buckets:                        []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+			NativeHistogramBucketFactor:    1.1,
+			NativeHistogramMaxBucketNumber: 256,
+		}),
+		rejected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Subsystem: subsystem,
+			Name:      "gate_rejected_total",
+			Help:      "Total number of requests rejected by the gate.",
+		}, []string{"reason"}), // queue_full, queue_timeout, context_cancelled
+	}
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Subsystem: subsystem,
+		Name:      "gate_workers",
+		Help:      "Number of workers serving requests concurrently.",
+	}, func() float64 { return float64(opts.Workers) })
+	return g
+}
+
+// Start blocks until a worker is free and takes it. Every successful Start
+// has to be followed by Done once the request was served.
+func (g *Gate) Start(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	start := time.Now()
+
+	// Fast path, a worker is idle.
+	select {
+	case g.workers <- struct{}{}:
+		g.busy.Inc()
+		g.queueWait.Observe(0)
+		return nil
+	default:
+	}
+
+	if g.queued.Add(1) > int64(g.opts.QueueSize) {
+		g.queued.Add(-1)
+		g.rejected.WithLabelValues("queue_full").Inc()
+		return ErrQueueFull
+	}
+	g.queueLen.Inc()
+	defer func() {
+		g.queued.Add(-1)
+		g.queueLen.Dec()
+	}()
+
+	var timeout <-chan time.Time
+	if g.opts.QueueTimeout > 0 {
+		t := time.NewTimer(g.opts.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case g.workers <- struct{}{}:
+		g.busy.Inc()
+		g.queueWait.Observe(time.Since(start).Seconds())
+		return nil
+	case <-ctx.Done():
+		g.rejected.WithLabelValues("context_cancelled").Inc()
+		return ctx.Err()
+	case <-timeout:
+		g.rejected.WithLabelValues("queue_timeout").Inc()
+		return ErrQueueTimeout
+	}
+}
+
+// Done releases the worker taken by Start.
+func (g *Gate) Done() {
+	if g == nil {
+		return
+	}
+	g.busy.Dec()
+	<-g.workers
+}
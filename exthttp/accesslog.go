@@ -0,0 +1,138 @@
+package exthttp
+
+import (
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AccessLogOpts configures the access log middleware.
+type AccessLogOpts struct {
+	// SampleRates are the probabilities (in %) of logging requests by status class, e.g.
+	// {"2xx": 1, "5xx": 100}. Requests of classes without a rate are always logged.
+	SampleRates map[string]float64
+
+	// SlowThreshold is the latency at which requests are logged as slow at warn level,
+	// regardless of sampling. Zero disables it.
+	SlowThreshold time.Duration
+}
+
+// ParseAccessLogSampleRates parses sample rates by status class in format:
+//
+//	<probability>%<class>,<probability>%<class>...
+//
+// with class one of 1xx to 5xx, e.g. "1%2xx,100%5xx".
+func ParseAccessLogSampleRates(s string) (map[string]float64, error) {
+	rates := map[string]float64{}
+	if s == "" {
+		return rates, nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		prob, class, ok := strings.Cut(strings.TrimSpace(e), "%")
+		if !ok {
+			return nil, errors.Errorf("invalid access log sample rate %q, expected <probability>%%<class>", e)
+		}
+		if len(class) != 3 || class[0] < '1' || class[0] > '5' || class[1:] != "xx" {
+			return nil, errors.Errorf("invalid status class %q, expected one of 1xx to 5xx", class)
+		}
+		f, err := strconv.ParseFloat(prob, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse probability %v as float", prob)
+		}
+		if f < 0 || f > 100 {
+			return nil, errors.Errorf("probability of %v has to be between 0 and 100, got %v", class, f)
+		}
+		rates[class] = f
+	}
+	return rates, nil
+}
+
+type accessLogMiddleware struct {
+	next   InstrumentationMiddleware
+	logger *slog.Logger
+	opts   AccessLogOpts
+}
+
+// NewAccessLogMiddleware provides an InstrumentationMiddleware logging a structured access log
+// of requests to logger, around the handlers of next. Requests are logged at info level, slow
+// requests at warn and requests failing with 5xx at error level.
+func NewAccessLogMiddleware(next InstrumentationMiddleware, logger *slog.Logger, opts AccessLogOpts) InstrumentationMiddleware {
+	return &accessLogMiddleware{next: next, logger: logger, opts: opts}
+}
+
+// NewHandler wraps the given HTTP handler, instrumented by the wrapped middleware, for access
+// logging. Logs have the method, path, handler, status, latency, bytes in and out, remote
+// address, user agent and trace ID of requests.
+func (ins *accessLogMiddleware) NewHandler(handlerName string, handler http.Handler) http.HandlerFunc {
+	next := ins.next.NewHandler(handlerName, handler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+
+		body := &countingReadCloser{ReadCloser: r.Body}
+		r.Body = body
+		wd := NewResponseWriterDelegator(w)
+		next.ServeHTTP(wd, r)
+		latency := time.Since(now)
+
+		// Handlers may not read the body, count it as received if its length is known.
+		bytesIn := max(body.n, r.ContentLength)
+		status := wd.StatusCode()
+		slow := ins.opts.SlowThreshold > 0 && latency >= ins.opts.SlowThreshold
+		if !slow && !ins.sampled(status) {
+			return
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case slow:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("handler", handlerName),
+			slog.Int("status", status),
+			slog.Duration("latency", latency),
+			slog.Int64("bytes_in", bytesIn),
+			slog.Int64("bytes_out", wd.Bytes()),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if traceID, _, _ := SpanIDs(r.Context()); traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", traceID))
+		}
+		if slow {
+			attrs = append(attrs, slog.Bool("slow", true))
+		}
+		ins.logger.LogAttrs(r.Context(), level, "access", attrs...)
+	}
+}
+
+// sampled decides whether to log a request with the given status.
+func (ins *accessLogMiddleware) sampled(status int) bool {
+	rate, ok := ins.opts.SampleRates[strconv.Itoa(status/100)+"xx"]
+	if !ok || rate >= 100 {
+		return true
+	}
+	return rand.Float64()*100 < rate
+}
+
+// countingReadCloser counts the bytes read from a request body.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -7,12 +7,31 @@ package exthttp
 
 import (
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Names of the HTTP client metrics, e.g. for generating rules and dashboards.
+const (
+	MetricClientInFlightRequests = "http_client_in_flight_requests"
+	MetricClientRequestsTotal    = "http_client_request_total"
+	MetricClientDNSDuration      = "http_client_dns_duration_seconds"
+	MetricClientTLSDuration      = "http_client_tls_duration_seconds"
+	MetricClientRequestDuration  = "http_client_request_duration_seconds"
+	MetricClientConnectDuration  = "http_client_connect_duration_seconds"
+	MetricClientGetConnDuration  = "http_client_get_conn_duration_seconds"
+	MetricClientTimeToFirstByte  = "http_client_time_to_first_byte_seconds"
+	MetricClientConnIdleDuration = "http_client_conn_idle_duration_seconds"
+	MetricClientConnsTotal       = "http_client_conns_total"
+)
+
 // ClientMetrics holds a collection of metrics that can be used to instrument a http client.
 // By setting this field in HTTPClientConfig, NewHTTPClient will create an instrumented client.
 type ClientMetrics struct {
@@ -21,6 +40,12 @@ type ClientMetrics struct {
 	dnsLatencyHistogram      *prometheus.HistogramVec
 	tlsLatencyHistogram      *prometheus.HistogramVec
 	requestDurationHistogram *prometheus.HistogramVec
+
+	connectLatencyHistogram *prometheus.HistogramVec
+	getConnLatencyHistogram prometheus.Histogram
+	ttfbHistogram           prometheus.Histogram
+	connIdleHistogram       prometheus.Histogram
+	connsTotalCount         *prometheus.CounterVec
 }
 
 // NewClientMetrics creates a new instance of ClientMetrics.
@@ -33,23 +58,20 @@ func NewClientMetrics(reg prometheus.Registerer) *ClientMetrics {
 	const bucketFactor = 1.1
 
 	m.inFlightGauge = promauto.With(reg).NewGauge(prometheus.GaugeOpts{
-		Subsystem: "http_client",
-		Name:      "in_flight_requests",
-		Help:      "A gauge of in-flight requests.",
+		Name: MetricClientInFlightRequests,
+		Help: "A gauge of in-flight requests.",
 	})
 
 	m.requestTotalCount = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
-		Subsystem: "http_client",
-		Name:      "request_total",
-		Help:      "Total http client request by code and method.",
-	}, []string{"code", "method"})
+		Name: MetricClientRequestsTotal,
+		Help: "Total http client request by code, method and protocol.",
+	}, []string{"code", "method", "proto"})
 
 	m.dnsLatencyHistogram = promauto.With(reg).NewHistogramVec(
 		prometheus.HistogramOpts{
-			Subsystem: "http_client",
-			Name:      "dns_duration_seconds",
-			Help:      "Trace dns latency histogram.",
-			Buckets:   []float64{0.025, .05, .1, .5, 1, 5, 10},
+			Name:    MetricClientDNSDuration,
+			Help:    "Trace dns latency histogram.",
+			Buckets: []float64{0.025, .05, .1, .5, 1, 5, 10},
 
 			NativeHistogramBucketFactor:    bucketFactor,
 			NativeHistogramMaxBucketNumber: maxBucketNumber,
@@ -59,10 +81,9 @@ func NewClientMetrics(reg prometheus.Registerer) *ClientMetrics {
 
 	m.tlsLatencyHistogram = promauto.With(reg).NewHistogramVec(
 		prometheus.HistogramOpts{
-			Subsystem: "http_client",
-			Name:      "tls_duration_seconds",
-			Help:      "Trace tls latency histogram.",
-			Buckets:   []float64{0.025, .05, .1, .5, 1, 5, 10},
+			Name:    MetricClientTLSDuration,
+			Help:    "Trace tls latency histogram.",
+			Buckets: []float64{0.025, .05, .1, .5, 1, 5, 10},
 
 			NativeHistogramBucketFactor:    bucketFactor,
 			NativeHistogramMaxBucketNumber: maxBucketNumber,
@@ -72,17 +93,66 @@ func NewClientMetrics(reg prometheus.Registerer) *ClientMetrics {
 
 	m.requestDurationHistogram = promauto.With(reg).NewHistogramVec(
 		prometheus.HistogramOpts{
-			Subsystem: "http_client",
-			Name:      "request_duration_seconds",
-			Help:      "A histogram of request latencies.",
-			Buckets:   []float64{0.025, .05, .1, .5, 1, 5, 10},
+			Name:    MetricClientRequestDuration,
+			Help:    "A histogram of request latencies.",
+			Buckets: []float64{0.025, .05, .1, .5, 1, 5, 10},
 
 			NativeHistogramBucketFactor:    bucketFactor,
 			NativeHistogramMaxBucketNumber: maxBucketNumber,
 		},
-		[]string{"code", "method"},
+		[]string{"code", "method", "proto"},
 	)
 
+	m.connectLatencyHistogram = promauto.With(reg).NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    MetricClientConnectDuration,
+			Help:    "Trace TCP connect latency histogram.",
+			Buckets: []float64{0.001, 0.005, 0.025, .05, .1, .5, 1, 5, 10},
+
+			NativeHistogramBucketFactor:    bucketFactor,
+			NativeHistogramMaxBucketNumber: maxBucketNumber,
+		},
+		[]string{"result"},
+	)
+
+	m.getConnLatencyHistogram = promauto.With(reg).NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    MetricClientGetConnDuration,
+			Help:    "Trace latency histogram of obtaining a connection, either from the idle pool or by dialing.",
+			Buckets: []float64{0.0001, 0.001, 0.005, 0.025, .05, .1, .5, 1, 5, 10},
+
+			NativeHistogramBucketFactor:    bucketFactor,
+			NativeHistogramMaxBucketNumber: maxBucketNumber,
+		},
+	)
+
+	m.ttfbHistogram = promauto.With(reg).NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    MetricClientTimeToFirstByte,
+			Help:    "Trace latency histogram from the start of the request until the first response byte.",
+			Buckets: []float64{0.025, .05, .1, .5, 1, 5, 10},
+
+			NativeHistogramBucketFactor:    bucketFactor,
+			NativeHistogramMaxBucketNumber: maxBucketNumber,
+		},
+	)
+
+	m.connIdleHistogram = promauto.With(reg).NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    MetricClientConnIdleDuration,
+			Help:    "Trace histogram of how long reused connections were idle before being picked up.",
+			Buckets: []float64{0.001, 0.01, 0.1, 1, 5, 10, 30, 60, 90},
+
+			NativeHistogramBucketFactor:    bucketFactor,
+			NativeHistogramMaxBucketNumber: maxBucketNumber,
+		},
+	)
+
+	m.connsTotalCount = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: MetricClientConnsTotal,
+		Help: "Total connections obtained for http client requests by whether they were reused.",
+	}, []string{"reused"})
+
 	return &m
 }
 
@@ -110,15 +180,93 @@ func InstrumentedRoundTripper(tripper http.RoundTripper, m *ClientMetrics) http.
 
 	return promhttp.InstrumentRoundTripperInFlight(
 		m.inFlightGauge,
-		promhttp.InstrumentRoundTripperCounter(
+		instrumentRoundTripperCounterAndDuration(
 			m.requestTotalCount,
+			m.requestDurationHistogram,
 			promhttp.InstrumentRoundTripperTrace(
 				trace,
-				promhttp.InstrumentRoundTripperDuration(
-					m.requestDurationHistogram,
-					tripper,
-				),
+				instrumentRoundTripperConnTrace(m, tripper),
 			),
 		),
 	)
 }
+
+// instrumentRoundTripperCounterAndDuration counts requests and observes their duration
+// partitioned by status code, method and the protocol the response was received with.
+// This is used instead of promhttp.InstrumentRoundTripperCounter and
+// promhttp.InstrumentRoundTripperDuration to be able to have the protocol as a label.
+func instrumentRoundTripperCounterAndDuration(counter *prometheus.CounterVec, obs prometheus.ObserverVec, next http.RoundTripper) promhttp.RoundTripperFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		labels := prometheus.Labels{
+			"code":   strconv.Itoa(resp.StatusCode),
+			"method": strings.ToLower(r.Method),
+			"proto":  responseProto(resp),
+		}
+		counter.With(labels).Inc()
+		obs.With(labels).Observe(time.Since(start).Seconds())
+		return resp, nil
+	}
+}
+
+// instrumentRoundTripperConnTrace traces the connection lifecycle of each request:
+// obtaining a connection, dialing it, whether it was reused and for how long it was idle,
+// and the time until the first response byte.
+// promhttp.InstrumentTrace only reports durations, so the httptrace hooks are used directly
+// to be able to see whether a connection was reused.
+func instrumentRoundTripperConnTrace(m *ClientMetrics, next http.RoundTripper) promhttp.RoundTripperFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		var (
+			start        = time.Now()
+			getConnStart time.Time
+
+			// Happy Eyeballs dials IPv4 and IPv6 addresses in parallel, so connect
+			// starts are tracked per network and address.
+			connectMtx    sync.Mutex
+			connectStarts = map[string]time.Time{}
+		)
+
+		trace := &httptrace.ClientTrace{
+			GetConn: func(string) {
+				getConnStart = time.Now()
+			},
+			GotConn: func(info httptrace.GotConnInfo) {
+				if !getConnStart.IsZero() {
+					m.getConnLatencyHistogram.Observe(time.Since(getConnStart).Seconds())
+				}
+				m.connsTotalCount.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+				if info.WasIdle {
+					m.connIdleHistogram.Observe(info.IdleTime.Seconds())
+				}
+			},
+			ConnectStart: func(network, addr string) {
+				connectMtx.Lock()
+				connectStarts[network+" "+addr] = time.Now()
+				connectMtx.Unlock()
+			},
+			ConnectDone: func(network, addr string, err error) {
+				connectMtx.Lock()
+				connectStart, ok := connectStarts[network+" "+addr]
+				delete(connectStarts, network+" "+addr)
+				connectMtx.Unlock()
+				if !ok {
+					return
+				}
+				result := "success"
+				if err != nil {
+					result = "error"
+				}
+				m.connectLatencyHistogram.WithLabelValues(result).Observe(time.Since(connectStart).Seconds())
+			},
+			GotFirstResponseByte: func() {
+				m.ttfbHistogram.Observe(time.Since(start).Seconds())
+			},
+		}
+
+		return next.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+	}
+}
@@ -0,0 +1,157 @@
+package exthttp
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// OtherLabelValue is recorded for extra label values rejected by the cardinality guard.
+const OtherLabelValue = "other"
+
+// LabelExtractor returns the value of an extra label for a request.
+type LabelExtractor func(r *http.Request) string
+
+// HeaderExtractor extracts the value of the given request header.
+func HeaderExtractor(name string) LabelExtractor {
+	return func(r *http.Request) string { return r.Header.Get(name) }
+}
+
+// PathValueExtractor extracts the value of the given wildcard of the ServeMux pattern the
+// request matched, e.g. "tenant" for "/{tenant}/ping".
+func PathValueExtractor(name string) LabelExtractor {
+	return func(r *http.Request) string { return r.PathValue(name) }
+}
+
+// QueryExtractor extracts the value of the given URL query parameter.
+func QueryExtractor(name string) LabelExtractor {
+	return func(r *http.Request) string { return r.URL.Query().Get(name) }
+}
+
+// ContextExtractor extracts the string value stored in the request context under key,
+// e.g. by an authentication middleware.
+func ContextExtractor(key any) LabelExtractor {
+	return func(r *http.Request) string {
+		v, _ := r.Context().Value(key).(string)
+		return v
+	}
+}
+
+// ExtraLabel declares an extra label of the HTTP server metrics.
+type ExtraLabel struct {
+	// Name is the label name, e.g. "tenant".
+	Name string
+
+	// Extract returns the label value of a request.
+	Extract LabelExtractor
+
+	// Values are the allowed label values. Other values are recorded as OtherLabelValue.
+	Values []string
+
+	// MaxValues limits the number of distinct values if Values is empty. Values beyond it
+	// are recorded as OtherLabelValue. Zero means no limit, which risks a cardinality explosion
+	// if clients control the value.
+	MaxValues int
+}
+
+// ParseExtraLabel parses an extra label from its flag format:
+//
+//	<name>=<source>:<key>[:<value>|<value>...]
+//
+// with source one of header, path or query, e.g. "tenant=header:X-Tenant:acme|globex".
+// Without values, at most maxValues distinct values are recorded.
+func ParseExtraLabel(s string, maxValues int) (ExtraLabel, error) {
+	name, spec, ok := strings.Cut(s, "=")
+	parts := strings.SplitN(spec, ":", 3)
+	if !ok || name == "" || len(parts) < 2 || parts[1] == "" {
+		return ExtraLabel{}, errors.Errorf("invalid extra label %q, expected <name>=<source>:<key>[:<value>|<value>...]", s)
+	}
+
+	if err := validateLabelName(name); err != nil {
+		return ExtraLabel{}, err
+	}
+
+	l := ExtraLabel{Name: name, MaxValues: maxValues}
+	switch parts[0] {
+	case "header":
+		l.Extract = HeaderExtractor(parts[1])
+	case "path":
+		l.Extract = PathValueExtractor(parts[1])
+	case "query":
+		l.Extract = QueryExtractor(parts[1])
+	default:
+		return ExtraLabel{}, errors.Errorf("unknown source %q of extra label %q, expected one of: header, path, query", parts[0], name)
+	}
+	if len(parts) == 3 {
+		l.Values = strings.Split(parts[2], "|")
+	}
+	return l, nil
+}
+
+// validateExtraLabels returns an error if an extra label has an invalid name, a name of the
+// labels the HTTP server metrics already have, or the name of another extra label.
+func validateExtraLabels(labels []ExtraLabel) error {
+	seen := make(map[string]struct{}, len(labels))
+	for _, l := range labels {
+		if err := validateLabelName(l.Name); err != nil {
+			return err
+		}
+		if _, ok := seen[l.Name]; ok {
+			return errors.Errorf("duplicate extra label %q", l.Name)
+		}
+		seen[l.Name] = struct{}{}
+	}
+	return nil
+}
+
+func validateLabelName(name string) error {
+	if !model.UTF8Validation.IsValidLabelName(name) || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+		return errors.Errorf("invalid extra label name %q", name)
+	}
+	switch name {
+	case "code", "handler", "method", "proto":
+		return errors.Errorf("extra label name %q is reserved for the HTTP server metrics", name)
+	}
+	return nil
+}
+
+// labelGuard bounds the cardinality of an extra label.
+type labelGuard struct {
+	ExtraLabel
+
+	mtx     sync.Mutex
+	allowed map[string]struct{}
+	fixed   bool // Whether allowed is a fixed set of Values, instead of the values seen so far.
+}
+
+func newLabelGuard(l ExtraLabel) *labelGuard {
+	g := &labelGuard{ExtraLabel: l, allowed: map[string]struct{}{}}
+	for _, v := range l.Values {
+		g.allowed[v] = struct{}{}
+	}
+	g.fixed = len(l.Values) > 0
+	return g
+}
+
+// value returns the label value of the request. Empty values are kept as they are.
+func (g *labelGuard) value(r *http.Request) string {
+	v := g.Extract(r)
+	if v == "" {
+		return ""
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if _, ok := g.allowed[v]; ok {
+		return v
+	}
+	if g.fixed || (g.MaxValues > 0 && len(g.allowed) >= g.MaxValues) {
+		return OtherLabelValue
+	}
+	g.allowed[v] = struct{}{}
+	return v
+}
@@ -10,6 +10,15 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Names of the HTTP server metrics, e.g. for generating rules and dashboards.
+const (
+	MetricRequestDuration  = "http_request_duration_seconds"
+	MetricRequestSize      = "http_request_size_bytes"
+	MetricRequestsTotal    = "http_requests_total"
+	MetricResponseSize     = "http_response_size_bytes"
+	MetricInflightRequests = "http_inflight_requests"
+)
+
 type defaultMetrics struct {
 	requestDuration      *prometheus.HistogramVec
 	requestSize          *prometheus.HistogramVec
@@ -30,51 +39,51 @@ func newDefaultMetrics(reg prometheus.Registerer, durationBuckets []float64, ext
 	return &defaultMetrics{
 		requestDuration: promauto.With(reg).NewHistogramVec(
 			prometheus.HistogramOpts{
-				Name:                           "http_request_duration_seconds",
+				Name:                           MetricRequestDuration,
 				Help:                           "Tracks the latencies for HTTP requests.",
 				Buckets:                        durationBuckets,
 				NativeHistogramBucketFactor:    bucketFactor,
 				NativeHistogramMaxBucketNumber: maxBuckets,
 			},
-			append([]string{"code", "handler", "method"}, extraLabels...),
+			append([]string{"code", "handler", "method", "proto"}, extraLabels...),
 		),
 
 		requestSize: promauto.With(reg).NewHistogramVec(
 			prometheus.HistogramOpts{
-				Name:                           "http_request_size_bytes",
+				Name:                           MetricRequestSize,
 				Help:                           "Tracks the size of HTTP requests.",
 				Buckets:                        bytesBuckets,
 				NativeHistogramBucketFactor:    bucketFactor,
 				NativeHistogramMaxBucketNumber: maxBuckets,
 			},
-			append([]string{"code", "handler", "method"}, extraLabels...),
+			append([]string{"code", "handler", "method", "proto"}, extraLabels...),
 		),
 
 		requestsTotal: promauto.With(reg).NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "http_requests_total",
+				Name: MetricRequestsTotal,
 				Help: "Tracks the number of HTTP requests.",
 			},
-			append([]string{"code", "handler", "method"}, extraLabels...),
+			append([]string{"code", "handler", "method", "proto"}, extraLabels...),
 		),
 
 		responseSize: promauto.With(reg).NewHistogramVec(
 			prometheus.HistogramOpts{
-				Name:                           "http_response_size_bytes",
+				Name:                           MetricResponseSize,
 				Help:                           "Tracks the size of HTTP responses.",
 				Buckets:                        bytesBuckets,
 				NativeHistogramBucketFactor:    bucketFactor,
 				NativeHistogramMaxBucketNumber: maxBuckets,
 			},
-			append([]string{"code", "handler", "method"}, extraLabels...),
+			append([]string{"code", "handler", "method", "proto"}, extraLabels...),
 		),
 
 		inflightHTTPRequests: promauto.With(reg).NewGaugeVec(
 			prometheus.GaugeOpts{
-				Name: "http_inflight_requests",
+				Name: MetricInflightRequests,
 				Help: "Current number of HTTP requests the handler is responding to.",
 			},
-			append([]string{"handler", "method"}, extraLabels...),
+			append([]string{"handler", "method", "proto"}, extraLabels...),
 		),
 	}
 }
@@ -0,0 +1,64 @@
+package exthttp
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// requestProto returns the protocol a server request was received with:
+// "http/1.0", "http/1.1", "h2" or "h2c".
+func requestProto(r *http.Request) string {
+	return protoLabel(r.ProtoMajor, r.Proto, r.TLS != nil)
+}
+
+// responseProto returns the protocol a client response was received with:
+// "http/1.0", "http/1.1", "h2" or "h2c".
+func responseProto(res *http.Response) string {
+	return protoLabel(res.ProtoMajor, res.Proto, res.TLS != nil)
+}
+
+func protoLabel(major int, proto string, tls bool) string {
+	switch {
+	case major == 2 && tls:
+		return "h2"
+	case major == 2:
+		return "h2c"
+	case proto == "":
+		return "unknown"
+	default:
+		return strings.ToLower(proto)
+	}
+}
+
+// ServerProtocols returns the protocols an http.Server should accept.
+// HTTP/1 is always enabled, HTTP/2 over TLS and cleartext HTTP/2 (h2c) are optional.
+func ServerProtocols(http2, h2c bool) *http.Protocols {
+	p := &http.Protocols{}
+	p.SetHTTP1(true)
+	p.SetHTTP2(http2)
+	p.SetUnencryptedHTTP2(h2c)
+	return p
+}
+
+// ClientProtocols parses a client protocol mode into the protocols an http.Transport should use.
+// Supported modes are "auto" (HTTP/2 over TLS if negotiated, HTTP/1.1 otherwise),
+// "http1" (HTTP/1.1 only), "h2" (HTTP/2 over TLS only) and "h2c" (cleartext HTTP/2 with prior knowledge).
+func ClientProtocols(mode string) (*http.Protocols, error) {
+	p := &http.Protocols{}
+	switch mode {
+	case "auto":
+		p.SetHTTP1(true)
+		p.SetHTTP2(true)
+	case "http1":
+		p.SetHTTP1(true)
+	case "h2":
+		p.SetHTTP2(true)
+	case "h2c":
+		p.SetUnencryptedHTTP2(true)
+	default:
+		return nil, errors.Errorf("unknown HTTP protocol %q, expected one of: auto, http1, h2, h2c", mode)
+	}
+	return p, nil
+}
@@ -9,13 +9,11 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/uber/jaeger-client-go"
-	"go.opentelemetry.io/otel/trace"
 )
 
 // InstrumentationMiddleware holds necessary metrics to instrument an http.Server
@@ -39,15 +37,61 @@ func NewNopInstrumentationMiddleware() InstrumentationMiddleware {
 }
 
 type defaultInstrumentationMiddleware struct {
-	metrics *defaultMetrics
+	metrics     *defaultMetrics
+	extraLabels []*labelGuard
+}
+
+// MiddlewareOption configures the InstrumentationMiddleware.
+type MiddlewareOption func(*middlewareOpts)
+
+type middlewareOpts struct {
+	buckets     []float64
+	extraLabels []ExtraLabel
+}
+
+// WithBuckets sets the buckets of http_request_duration_seconds.
+func WithBuckets(buckets []float64) MiddlewareOption {
+	return func(o *middlewareOpts) {
+		o.buckets = buckets
+	}
+}
+
+// WithExtraLabels adds labels to all HTTP server metrics, with values extracted per request.
+func WithExtraLabels(labels ...ExtraLabel) MiddlewareOption {
+	return func(o *middlewareOpts) {
+		o.extraLabels = append(o.extraLabels, labels...)
+	}
 }
 
 // NewInstrumentationMiddleware provides default InstrumentationMiddleware.
 // Passing nil as buckets uses the default buckets.
 func NewInstrumentationMiddleware(reg prometheus.Registerer, buckets []float64) InstrumentationMiddleware {
-	return &defaultInstrumentationMiddleware{
-		metrics: newDefaultMetrics(reg, buckets, []string{}),
+	return newInstrumentationMiddleware(reg, middlewareOpts{buckets: buckets})
+}
+
+// NewInstrumentationMiddlewareWithOptions provides default InstrumentationMiddleware configured by options.
+// It returns an error if an extra label has an invalid name, a name of the labels the HTTP server
+// metrics already have, or the name of another extra label.
+func NewInstrumentationMiddlewareWithOptions(reg prometheus.Registerer, opts ...MiddlewareOption) (InstrumentationMiddleware, error) {
+	o := middlewareOpts{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := validateExtraLabels(o.extraLabels); err != nil {
+		return nil, err
+	}
+	return newInstrumentationMiddleware(reg, o), nil
+}
+
+func newInstrumentationMiddleware(reg prometheus.Registerer, o middlewareOpts) *defaultInstrumentationMiddleware {
+	ins := &defaultInstrumentationMiddleware{}
+	names := make([]string, 0, len(o.extraLabels))
+	for _, l := range o.extraLabels {
+		names = append(names, l.Name)
+		ins.extraLabels = append(ins.extraLabels, newLabelGuard(l))
 	}
+	ins.metrics = newDefaultMetrics(reg, o.buckets, names)
+	return ins
 }
 
 // NewHandler wraps the given HTTP handler for instrumentation. It
@@ -55,13 +99,35 @@ func NewInstrumentationMiddleware(reg prometheus.Registerer, buckets []float64)
 // metrics to the (newly or already) registered collectors: http_requests_total
 // (CounterVec), http_request_duration_seconds (Histogram),
 // http_request_size_bytes (Summary), http_response_size_bytes (Summary).
-// Each has a constant label named "handler" with the provided handlerName as value.
+// Each has a constant label named "handler" with the provided handlerName as value
+// and a "proto" label with the protocol the request was received with (http/1.1, h2 or h2c),
+// plus the configured extra labels.
 func (ins *defaultInstrumentationMiddleware) NewHandler(handlerName string, handler http.Handler) http.HandlerFunc {
-	baseLabels := prometheus.Labels{"handler": handlerName}
-	return httpInstrumentationHandler(baseLabels, ins.metrics, handler)
+	// Instrumented handlers by their label values, which the cardinality guards bound.
+	// Curried once instead of on every request.
+	var handlers sync.Map
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := make([]string, 0, 1+len(ins.extraLabels))
+		values = append(values, requestProto(r))
+		for _, l := range ins.extraLabels {
+			values = append(values, l.value(r))
+		}
+		key := strings.Join(values, "\xff")
+
+		h, ok := handlers.Load(key)
+		if !ok {
+			baseLabels := prometheus.Labels{"handler": handlerName, "proto": values[0]}
+			for i, l := range ins.extraLabels {
+				baseLabels[l.Name] = values[i+1]
+			}
+			h, _ = handlers.LoadOrStore(key, httpInstrumentationHandler(baseLabels, ins.metrics, handler))
+		}
+		h.(http.Handler).ServeHTTP(w, r)
+	}
 }
 
 func httpInstrumentationHandler(baseLabels prometheus.Labels, metrics *defaultMetrics, next http.Handler) http.HandlerFunc {
+	requestDuration := metrics.requestDuration.MustCurryWith(baseLabels)
 	return promhttp.InstrumentHandlerRequestSize(
 		metrics.requestSize.MustCurryWith(baseLabels),
 		instrumentHandlerInFlight(
@@ -73,38 +139,16 @@ func httpInstrumentationHandler(baseLabels prometheus.Labels, metrics *defaultMe
 					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 						now := time.Now()
 
-						wd := &responseWriterDelegator{w: w}
+						wd := NewResponseWriterDelegator(w)
 						next.ServeHTTP(wd, r)
 
 						requestLabels := prometheus.Labels{"code": wd.Status(), "method": strings.ToLower(r.Method)}
-						observer := metrics.requestDuration.MustCurryWith(baseLabels).With(requestLabels)
+						observer := requestDuration.With(requestLabels)
 						requestDuration := time.Since(now).Seconds()
 
-						// If we find a tracingID we'll expose it as Exemplar.
-						var (
-							traceID string
-							OTfound bool
-						)
-
-						span := opentracing.SpanFromContext(r.Context())
-						if span != nil {
-							spanCtx, ok := span.Context().(jaeger.SpanContext)
-							if ok && spanCtx.IsSampled() {
-								traceID = spanCtx.TraceID().String()
-							}
-
-							OTfound = ok
-						}
-
-						// If OpenTracing span not found, try OTEL.
-						if !OTfound {
-							span := trace.SpanFromContext(r.Context())
-							if span != nil && span.SpanContext().IsSampled() {
-								traceID = span.SpanContext().TraceID().String()
-							}
-						}
-
-						if traceID != "" {
+						// If we find a sampled tracingID we'll expose it as Exemplar.
+						traceID, _, sampled := SpanIDs(r.Context())
+						if sampled {
 							observer.(prometheus.ExemplarObserver).ObserveWithExemplar(
 								requestDuration,
 								prometheus.Labels{
@@ -121,38 +165,67 @@ func httpInstrumentationHandler(baseLabels prometheus.Labels, metrics *defaultMe
 	)
 }
 
-// responseWriterDelegator implements http.ResponseWriter and extracts the statusCode.
-type responseWriterDelegator struct {
+// ResponseWriterDelegator implements http.ResponseWriter and extracts the statusCode
+// and the number of written body bytes.
+type ResponseWriterDelegator struct {
 	w          http.ResponseWriter
 	written    bool
 	statusCode int
+	bytes      int64
+}
+
+// NewResponseWriterDelegator wraps w to record the status and size of the response.
+func NewResponseWriterDelegator(w http.ResponseWriter) *ResponseWriterDelegator {
+	return &ResponseWriterDelegator{w: w}
 }
 
-func (wd *responseWriterDelegator) Header() http.Header {
+func (wd *ResponseWriterDelegator) Header() http.Header {
 	return wd.w.Header()
 }
 
-func (wd *responseWriterDelegator) Write(bytes []byte) (int, error) {
-	return wd.w.Write(bytes)
+func (wd *ResponseWriterDelegator) Write(bytes []byte) (int, error) {
+	if !wd.written {
+		wd.written = true
+		wd.statusCode = http.StatusOK
+	}
+	n, err := wd.w.Write(bytes)
+	wd.bytes += int64(n)
+	return n, err
 }
 
-func (wd *responseWriterDelegator) WriteHeader(statusCode int) {
-	wd.written = true
-	wd.statusCode = statusCode
+func (wd *ResponseWriterDelegator) WriteHeader(statusCode int) {
+	// Like net/http, ignore superfluous calls.
+	if !wd.written {
+		wd.written = true
+		wd.statusCode = statusCode
+	}
 	wd.w.WriteHeader(statusCode)
 }
 
-func (wd *responseWriterDelegator) StatusCode() int {
+// Unwrap lets http.ResponseController reach the underlying http.ResponseWriter,
+// e.g. to flush streamed responses.
+func (wd *ResponseWriterDelegator) Unwrap() http.ResponseWriter {
+	return wd.w
+}
+
+// StatusCode returns the status of the response, http.StatusOK if none was written yet.
+func (wd *ResponseWriterDelegator) StatusCode() int {
 	if !wd.written {
 		return http.StatusOK
 	}
 	return wd.statusCode
 }
 
-func (wd *responseWriterDelegator) Status() string {
+// Status returns the status of the response as a string, e.g. for metric labels.
+func (wd *ResponseWriterDelegator) Status() string {
 	return fmt.Sprintf("%d", wd.StatusCode())
 }
 
+// Bytes returns the number of written body bytes.
+func (wd *ResponseWriterDelegator) Bytes() int64 {
+	return wd.bytes
+}
+
 // instrumentHandlerInFlight is responsible for counting the amount of
 // in-flight HTTP requests (requests being processed by the handler) at a given
 // moment in time.
@@ -0,0 +1,426 @@
+package exthttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TLSServerOpts configures TLS for an HTTP server.
+type TLSServerOpts struct {
+	// CertFile and KeyFile are the paths to the PEM encoded serving certificate and key.
+	// They are re-read whenever their modification time changes.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the path to a PEM encoded CA bundle. If set, clients have to
+	// present a certificate signed by one of these CAs (mTLS).
+	ClientCAFile string
+
+	// SelfSigned generates an ephemeral CA and a serving certificate signed by it,
+	// valid for localhost and the machine hostname. Used for local testing only.
+	SelfSigned bool
+
+	// SelfSignedCAFile is an optional path the ephemeral CA certificate is written to,
+	// so that clients can be configured to trust it.
+	SelfSignedCAFile string
+
+	// SelfSignedValidity is how long the ephemeral CA and serving certificate are valid.
+	SelfSignedValidity time.Duration
+}
+
+// Enabled returns true if any TLS serving option is set.
+func (o TLSServerOpts) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != "" || o.SelfSigned
+}
+
+// TLSClientOpts configures TLS for an HTTP client.
+type TLSClientOpts struct {
+	// CertFile and KeyFile are the paths to the PEM encoded client certificate and key
+	// presented to servers requiring mTLS. They are re-read whenever they change.
+	CertFile string
+	KeyFile  string
+
+	// CAFile is the path to a PEM encoded CA bundle used to verify the server.
+	// System roots are used if empty. It is re-read whenever it changes.
+	CAFile string
+
+	// ServerName overrides the server name used for verification. It is required to verify
+	// servers addressed by IP against CAFile.
+	ServerName string
+
+	// InsecureSkipVerify disables server certificate verification.
+	InsecureSkipVerify bool
+}
+
+// NewServerTLSConfig creates a server TLS config from the given options.
+// It returns nil if TLS is not enabled.
+func NewServerTLSConfig(opts TLSServerOpts) (*tls.Config, error) {
+	if !opts.Enabled() {
+		if opts.ClientCAFile != "" {
+			return nil, errors.New("client CA requires a serving certificate or self-signed mode")
+		}
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch {
+	case opts.SelfSigned:
+		if opts.CertFile != "" || opts.KeyFile != "" {
+			return nil, errors.New("self-signed mode cannot be combined with a certificate and key")
+		}
+		if opts.SelfSignedValidity <= 0 {
+			return nil, errors.Errorf("self-signed certificate validity has to be positive, got %v", opts.SelfSignedValidity)
+		}
+		ca, err := newSelfSignedCA(opts.SelfSignedValidity)
+		if err != nil {
+			return nil, errors.Wrap(err, "generate self-signed CA")
+		}
+		cert, err := ca.issue()
+		if err != nil {
+			return nil, errors.Wrap(err, "issue self-signed serving certificate")
+		}
+		if opts.SelfSignedCAFile != "" {
+			if err := os.WriteFile(opts.SelfSignedCAFile, ca.certPEM, 0o644); err != nil {
+				return nil, errors.Wrap(err, "write self-signed CA certificate")
+			}
+		}
+		slog.Info("generated self-signed serving certificate", "ca_file", opts.SelfSignedCAFile, "not_after", ca.cert.NotAfter)
+		cfg.Certificates = []tls.Certificate{*cert}
+	default:
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, errors.New("both certificate and key have to be provided")
+		}
+		kp, err := newKeyPairReloader(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return kp.get()
+		}
+	}
+
+	if opts.ClientCAFile == "" {
+		return cfg, nil
+	}
+
+	ca, err := newCAReloader(opts.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	// Verify client certificates against the current client CA after the handshake instead of
+	// swapping the config per handshake, which would drop what http.Server sets on its copy of
+	// the config, like the NextProtos HTTP/2 is negotiated with.
+	cfg.ClientAuth = tls.RequireAnyClientCert
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		pool, err := ca.get()
+		if err != nil {
+			return err
+		}
+		return verifyPeerCertificates(cs.PeerCertificates, pool, "", x509.ExtKeyUsageClientAuth)
+	}
+	return cfg, nil
+}
+
+// verifyPeerCertificates verifies the certificate chain presented by a peer against roots,
+// like crypto/tls does during the handshake.
+func verifyPeerCertificates(certs []*x509.Certificate, roots *x509.CertPool, dnsName string, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return errors.New("no peer certificate presented")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       dnsName,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return errors.Wrap(err, "verify peer certificate")
+	}
+	return nil
+}
+
+// NewClientTLSConfig creates a client TLS config from the given options.
+func NewClientTLSConfig(opts TLSClientOpts) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CAFile != "" && !opts.InsecureSkipVerify {
+		ca, err := newCAReloader(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		// Root CAs can't be swapped per handshake, so the server is verified against the current
+		// CA after the handshake instead. SNI is not sent for IP addresses, so their name has to be
+		// configured to be verified.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			serverName := cs.ServerName
+			if serverName == "" {
+				serverName = opts.ServerName
+			}
+			if serverName == "" {
+				return errors.New("server name required to verify the server certificate")
+			}
+			pool, err := ca.get()
+			if err != nil {
+				return err
+			}
+			return verifyPeerCertificates(cs.PeerCertificates, pool, serverName, x509.ExtKeyUsageServerAuth)
+		}
+	}
+
+	if opts.CertFile == "" && opts.KeyFile == "" {
+		return cfg, nil
+	}
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("both client certificate and key have to be provided")
+	}
+	kp, err := newKeyPairReloader(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return kp.get()
+	}
+	return cfg, nil
+}
+
+// reloadInterval bounds how often watched files are stat'ed on the handshake path.
+const reloadInterval = 5 * time.Second
+
+// fileWatcher tracks modification times of a set of files.
+type fileWatcher struct {
+	files     []string
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+// changed returns true if any watched file was modified since the last call.
+func (f *fileWatcher) changed() (bool, error) {
+	if time.Since(f.lastCheck) < reloadInterval && f.modTimes != nil {
+		return false, nil
+	}
+	f.lastCheck = time.Now()
+
+	modTimes := make([]time.Time, 0, len(f.files))
+	for _, file := range f.files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return false, errors.Wrapf(err, "stat %v", file)
+		}
+		modTimes = append(modTimes, fi.ModTime())
+	}
+
+	changed := f.modTimes == nil
+	for i := range f.modTimes {
+		if !f.modTimes[i].Equal(modTimes[i]) {
+			changed = true
+		}
+	}
+	f.modTimes = modTimes
+	return changed, nil
+}
+
+// keyPairReloader serves a certificate, reloading it from disk when it changes.
+type keyPairReloader struct {
+	certFile, keyFile string
+
+	mtx     sync.Mutex
+	watcher fileWatcher
+	cert    *tls.Certificate
+}
+
+func newKeyPairReloader(certFile, keyFile string) (*keyPairReloader, error) {
+	k := &keyPairReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		watcher:  fileWatcher{files: []string{certFile, keyFile}},
+	}
+	if _, err := k.get(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *keyPairReloader) get() (*tls.Certificate, error) {
+	k.mtx.Lock()
+	defer k.mtx.Unlock()
+
+	changed, err := k.watcher.changed()
+	if err != nil {
+		if k.cert == nil {
+			return nil, err
+		}
+		slog.Warn("failed to check certificate for changes, serving previous one", "cert", k.certFile, "error", err)
+		return k.cert, nil
+	}
+	if !changed {
+		return k.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		if k.cert != nil {
+			slog.Warn("failed to reload certificate, serving previous one", "cert", k.certFile, "error", err)
+			return k.cert, nil
+		}
+		return nil, errors.Wrapf(err, "load key pair %v %v", k.certFile, k.keyFile)
+	}
+	if k.cert != nil {
+		slog.Info("reloaded certificate", "cert", k.certFile)
+	}
+	k.cert = &cert
+	return k.cert, nil
+}
+
+// caReloader serves a CA pool, reloading it from disk when it changes.
+type caReloader struct {
+	file string
+
+	mtx     sync.Mutex
+	watcher fileWatcher
+	pool    *x509.CertPool
+}
+
+func newCAReloader(file string) (*caReloader, error) {
+	c := &caReloader{file: file, watcher: fileWatcher{files: []string{file}}}
+	if _, err := c.get(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *caReloader) get() (*x509.CertPool, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	changed, err := c.watcher.changed()
+	if err != nil {
+		if c.pool == nil {
+			return nil, err
+		}
+		slog.Warn("failed to check CA for changes, serving previous one", "ca", c.file, "error", err)
+		return c.pool, nil
+	}
+	if !changed {
+		return c.pool, nil
+	}
+
+	b, err := os.ReadFile(c.file)
+	if err != nil {
+		if c.pool != nil {
+			slog.Warn("failed to reload CA, serving previous one", "ca", c.file, "error", err)
+			return c.pool, nil
+		}
+		return nil, errors.Wrapf(err, "read CA %v", c.file)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		if c.pool != nil {
+			slog.Warn("no certificates found in reloaded CA, serving previous one", "ca", c.file)
+			return c.pool, nil
+		}
+		return nil, errors.Errorf("no certificates found in CA %v", c.file)
+	}
+	if c.pool != nil {
+		slog.Info("reloaded CA", "ca", c.file)
+	}
+	c.pool = pool
+	return c.pool, nil
+}
+
+// selfSignedCA is an ephemeral in-memory certificate authority.
+type selfSignedCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+// newSelfSignedCA generates a CA valid for the given duration.
+func newSelfSignedCA(validity time.Duration) (*selfSignedCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "pingpong ephemeral CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &selfSignedCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}, nil
+}
+
+// issue creates a serving certificate for localhost and the machine hostname.
+func (ca *selfSignedCA) issue() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	dnsNames := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "pingpong"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     ca.cert.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+	}, nil
+}
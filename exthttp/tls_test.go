@@ -0,0 +1,110 @@
+package exthttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestMTLSNegotiatesHTTP2(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := newSelfSignedCA(time.Hour)
+	if err != nil {
+		t.Fatalf("generate CA: %v", err)
+	}
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, ca.certPEM, 0o644); err != nil {
+		t.Fatalf("write CA: %v", err)
+	}
+	serverCert, serverKey := writeIssuedKeyPair(t, ca, dir, "server")
+	clientCert, clientKey := writeIssuedKeyPair(t, ca, dir, "client")
+
+	serverCfg, err := NewServerTLSConfig(TLSServerOpts{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatalf("create server TLS config: %v", err)
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.TLS.NegotiatedProtocol)
+		}),
+		TLSConfig: serverCfg,
+		Protocols: ServerProtocols(true, false),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() {
+		if err := srv.ServeTLS(l, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("serve: %v", err)
+		}
+	}()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	newClient := func(opts TLSClientOpts) *http.Client {
+		t.Helper()
+		cfg, err := NewClientTLSConfig(opts)
+		if err != nil {
+			t.Fatalf("create client TLS config: %v", err)
+		}
+		protocols, err := ClientProtocols("h2")
+		if err != nil {
+			t.Fatalf("client protocols: %v", err)
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, Protocols: protocols}}
+	}
+	url := "https://" + l.Addr().String()
+
+	c := newClient(TLSClientOpts{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile, ServerName: "localhost"})
+	res, err := c.Get(url)
+	if err != nil {
+		t.Fatalf("mTLS request: %v", err)
+	}
+	b, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if res.ProtoMajor != 2 || string(b) != "h2" {
+		t.Errorf("expected h2 to be negotiated, got proto %q and negotiated protocol %q", res.Proto, b)
+	}
+
+	// Clients without a certificate are rejected.
+	c = newClient(TLSClientOpts{CAFile: caFile, ServerName: "localhost"})
+	if res, err := c.Get(url); err == nil {
+		_ = res.Body.Close()
+		t.Error("expected request without client certificate to fail")
+	}
+}
+
+// writeIssuedKeyPair issues a certificate signed by ca and writes it and its key to dir.
+func writeIssuedKeyPair(t *testing.T, ca *selfSignedCA, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	cert, err := ca.issue()
+	if err != nil {
+		t.Fatalf("issue certificate: %v", err)
+	}
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(certFile, certPEM, 0o644); err != nil {
+		t.Fatalf("write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
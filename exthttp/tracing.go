@@ -0,0 +1,37 @@
+package exthttp
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanIDs returns the trace and span ID of the span in ctx and whether it is sampled.
+// OpenTracing spans of the Jaeger client take precedence over OpenTelemetry spans.
+// The IDs are empty without a span.
+func SpanIDs(ctx context.Context) (traceID, spanID string, sampled bool) {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if spanCtx, ok := span.Context().(jaeger.SpanContext); ok {
+			return spanCtx.TraceID().String(), spanCtx.SpanID().String(), spanCtx.IsSampled()
+		}
+	}
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		return spanCtx.TraceID().String(), spanCtx.SpanID().String(), spanCtx.IsSampled()
+	}
+	return "", "", false
+}
+
+// ExtractTraceContext makes the span of the W3C traceparent header of requests available in
+// their context, so requests of traced clients can be correlated with metrics and logs even
+// without a tracer.
+func ExtractTraceContext(next http.Handler) http.Handler {
+	propagator := propagation.TraceContext{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,398 @@
+// Package extlog generates a configurable log workload for testing log pipelines: access logs
+// of HTTP requests, synthetic application logs with a level mix and multi-line stack traces on
+// errors, correlated with traces by the IDs of spans in the request context.
+package extlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saswatamcode/pingpong/exthttp"
+	"github.com/saswatamcode/pingpong/extrand"
+)
+
+// Outputs of the log workload.
+const (
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+	OutputOTLP   = "otlp"
+)
+
+// Formats of application logs.
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// Formats of access logs.
+const (
+	AccessCommon   = "common"
+	AccessCombined = "combined"
+	AccessJSON     = "json"
+)
+
+// Opts configures the log workload.
+type Opts struct {
+	// Output is where logs are written to, one of OutputStderr, OutputFile or OutputOTLP.
+	Output string
+
+	// File is the path of the log file for OutputFile.
+	File string
+
+	// MaxFileSize is the size the log file is rotated at, zero means no rotation.
+	MaxFileSize int64
+
+	// MaxFiles is the number of rotated log files kept.
+	MaxFiles int
+
+	// OTLPURL is the OTLP/HTTP endpoint for OutputOTLP, e.g. http://localhost:4318.
+	OTLPURL string
+
+	// ServiceName is the service.name resource attribute of OTLP logs.
+	ServiceName string
+
+	// Format is the format of application logs written to stderr or files, one of
+	// FormatLogfmt or FormatJSON. Stack traces follow logfmt records as separate lines.
+	Format string
+
+	// AccessFormat is the format of access logs, one of AccessCommon, AccessCombined or
+	// AccessJSON. Empty disables access logs.
+	AccessFormat string
+
+	// AppRate is the number of synthetic application logs per second.
+	AppRate float64
+
+	// AppLevels is the encoded level mix of synthetic application logs in format:
+	// <probability>%<level>,<probability>%<level>... e.g. 70%info,20%debug,8%warn,2%error.
+	AppLevels string
+}
+
+// DefaultOpts returns default log workload options.
+func DefaultOpts() Opts {
+	return Opts{
+		Output:       OutputStderr,
+		MaxFileSize:  100 << 20,
+		MaxFiles:     5,
+		OTLPURL:      "http://localhost:4318",
+		Format:       FormatLogfmt,
+		AccessFormat: AccessCombined,
+		AppRate:      10,
+		AppLevels:    "70%info,20%debug,8%warn,2%error",
+	}
+}
+
+// Logger writes the log workload.
+type Logger struct {
+	opts    Opts
+	levels  *extrand.WeightedChoice[slog.Level]
+	records *prometheus.CounterVec
+
+	// Records are rendered into buf and written to w at once, so multi-line records are not
+	// interleaved or split by rotation. w is nil for OTLP.
+	mtx     sync.Mutex
+	buf     bytes.Buffer
+	w       io.Writer
+	handler slog.Handler
+	close   func(context.Context) error
+}
+
+// New creates a Logger writing the log workload to the configured output.
+func New(reg prometheus.Registerer, opts Opts) (*Logger, error) {
+	levels, err := extrand.NewWeightedChoice(opts.AppLevels, parseLevel)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing application log levels")
+	}
+	if opts.AppRate < 0 {
+		return nil, errors.Errorf("application log rate must not be negative, got %v", opts.AppRate)
+	}
+	switch opts.AccessFormat {
+	case "", AccessCommon, AccessCombined, AccessJSON:
+	default:
+		return nil, errors.Errorf("unknown access log format %q, expected one of: %s, %s, %s", opts.AccessFormat, AccessCommon, AccessCombined, AccessJSON)
+	}
+
+	l := &Logger{
+		opts:   opts,
+		levels: levels,
+		close:  func(context.Context) error { return nil },
+		records: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "synthetic_log",
+			Name:      "records_total",
+			Help:      "Total number of synthetic log records written.",
+		}, []string{"type", "level"}), // type: access, app
+	}
+
+	switch opts.Output {
+	case OutputStderr:
+		l.w = os.Stderr
+	case OutputFile:
+		f, err := OpenRotatingFile(opts.File, opts.MaxFileSize, opts.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		l.w = f
+		l.close = func(context.Context) error { return f.Close() }
+	case OutputOTLP:
+		h, shutdown, err := newOTLPHandler(opts.OTLPURL, opts.ServiceName)
+		if err != nil {
+			return nil, err
+		}
+		l.handler, l.close = h, shutdown
+	default:
+		return nil, errors.Errorf("unknown log output %q, expected one of: %s, %s, %s", opts.Output, OutputStderr, OutputFile, OutputOTLP)
+	}
+
+	if l.w != nil {
+		hopts := &slog.HandlerOptions{Level: slog.LevelDebug}
+		switch opts.Format {
+		case FormatLogfmt:
+			l.handler = NewTraceHandler(slog.NewTextHandler(&l.buf, hopts))
+		case FormatJSON:
+			l.handler = NewTraceHandler(slog.NewJSONHandler(&l.buf, hopts))
+		default:
+			return nil, errors.Errorf("unknown log format %q, expected one of: %s, %s", opts.Format, FormatLogfmt, FormatJSON)
+		}
+	}
+	return l, nil
+}
+
+// Close flushes and closes the output.
+func (l *Logger) Close(ctx context.Context) error {
+	return l.close(ctx)
+}
+
+// Error logs an application error with the stack trace of the caller.
+func (l *Logger) Error(ctx context.Context, msg string, attrs ...slog.Attr) {
+	l.log(ctx, slog.LevelError, msg, string(debug.Stack()), attrs...)
+}
+
+// log writes an application log record, followed by the stack trace if not empty.
+func (l *Logger) log(ctx context.Context, level slog.Level, msg, stack string, attrs ...slog.Attr) {
+	l.records.WithLabelValues("app", strings.ToLower(level.String())).Inc()
+
+	r := slog.NewRecord(time.Now(), level, msg, 0)
+	r.AddAttrs(attrs...)
+	if stack != "" && (l.w == nil || l.opts.Format == FormatJSON) {
+		r.AddAttrs(slog.String("exception.stacktrace", stack))
+	}
+
+	if l.w == nil {
+		_ = l.handler.Handle(ctx, r)
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.buf.Reset()
+	_ = l.handler.Handle(ctx, r)
+	if stack != "" && l.opts.Format == FormatLogfmt {
+		l.buf.WriteString(stack)
+	}
+	_, _ = l.w.Write(l.buf.Bytes())
+}
+
+// accessEntry is an access log entry, in the order of the JSON access log format.
+type accessEntry struct {
+	Time       string  `json:"time"`
+	RemoteAddr string  `json:"remote_addr"`
+	User       string  `json:"user,omitempty"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	TraceID    string  `json:"trace_id,omitempty"`
+	SpanID     string  `json:"span_id,omitempty"`
+}
+
+// AccessHandler wraps next, writing an access log entry for every request.
+func (l *Logger) AccessHandler(next http.Handler) http.Handler {
+	if l.opts.AccessFormat == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wd := exthttp.NewResponseWriterDelegator(w)
+		next.ServeHTTP(wd, r)
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		e := accessEntry{
+			RemoteAddr: host,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Proto:      r.Proto,
+			Status:     wd.StatusCode(),
+			Bytes:      wd.Bytes(),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		}
+		if user, _, ok := r.BasicAuth(); ok {
+			e.User = user
+		}
+		e.TraceID, e.SpanID, _ = exthttp.SpanIDs(r.Context())
+		l.access(r.Context(), start, e)
+	})
+}
+
+func (l *Logger) access(ctx context.Context, start time.Time, e accessEntry) {
+	level := slog.LevelInfo
+	if e.Status >= 500 {
+		level = slog.LevelError
+	} else if e.Status >= 400 {
+		level = slog.LevelWarn
+	}
+	l.records.WithLabelValues("access", strings.ToLower(level.String())).Inc()
+
+	var line string
+	if l.opts.AccessFormat == AccessJSON {
+		e.Time = start.Format(time.RFC3339Nano)
+		b, _ := json.Marshal(e)
+		line = string(b)
+	} else {
+		line = l.formatCLF(start, e)
+	}
+
+	if l.w == nil {
+		// OTLP records carry the line as body, the fields as attributes and the trace and
+		// span ID as fields of the record.
+		r := slog.NewRecord(start, level, line, 0)
+		r.AddAttrs(
+			slog.String("http.request.method", e.Method),
+			slog.String("url.path", e.Path),
+			slog.Int("http.response.status_code", e.Status),
+			slog.Int64("http.response.body.size", e.Bytes),
+			slog.String("client.address", e.RemoteAddr),
+			slog.String("user_agent.original", e.UserAgent),
+		)
+		_ = l.handler.Handle(ctx, r)
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	_, _ = io.WriteString(l.w, line+"\n")
+}
+
+// formatCLF formats an entry in Common or Combined Log Format, followed by the trace and span
+// ID if any.
+func (l *Logger) formatCLF(start time.Time, e accessEntry) string {
+	user, size := "-", "-"
+	if e.User != "" {
+		user = e.User
+	}
+	if e.Bytes > 0 {
+		size = strconv.FormatInt(e.Bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s", e.RemoteAddr, user, start.Format("02/Jan/2006:15:04:05 -0700"), e.Method+" "+e.Path+" "+e.Proto, e.Status, size)
+	if l.opts.AccessFormat == AccessCombined {
+		line += fmt.Sprintf(" %q %q", orDash(e.Referer), orDash(e.UserAgent))
+	}
+	if e.TraceID != "" {
+		line += " trace_id=" + e.TraceID + " span_id=" + e.SpanID
+	}
+	return line
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Run writes synthetic application logs at the configured rate until ctx is done, then closes
+// the output.
+func (l *Logger) Run(ctx context.Context) error {
+	slog.Info("writing synthetic logs", "output", l.opts.Output, "format", l.opts.Format, "access_format", l.opts.AccessFormat, "app_rate", l.opts.AppRate, "app_levels", l.opts.AppLevels)
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := l.Close(closeCtx); err != nil {
+			slog.Error("failed to close synthetic log output", "error", err)
+		}
+	}()
+
+	// Tick often enough to spread logs evenly, carrying fractions of logs between ticks.
+	const tick = 100 * time.Millisecond
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	due := 0.0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+			due += l.opts.AppRate * tick.Seconds()
+			for ; due >= 1; due-- {
+				l.logSynthetic(ctx)
+			}
+		}
+	}
+}
+
+// logSynthetic writes a random synthetic application log record.
+func (l *Logger) logSynthetic(ctx context.Context) {
+	level, _ := l.levels.Pick()
+	user := slog.String("user_id", "user-"+strconv.Itoa(rand.Intn(1000)))
+	switch level {
+	case slog.LevelDebug:
+		switch rand.Intn(2) {
+		case 0:
+			l.log(ctx, level, "cache lookup", "", slog.String("key", "session:"+strconv.Itoa(rand.Intn(10000))), slog.Bool("hit", rand.Intn(5) > 0))
+		default:
+			l.log(ctx, level, "query executed", "", slog.String("table", randomOf("users", "orders", "payments")), slog.Int("rows", rand.Intn(100)))
+		}
+	case slog.LevelInfo:
+		switch rand.Intn(3) {
+		case 0:
+			l.log(ctx, level, "order created", "", user, slog.String("order_id", fmt.Sprintf("ord-%08x", rand.Uint32())), slog.Float64("amount", float64(rand.Intn(100000))/100))
+		case 1:
+			l.log(ctx, level, "user logged in", "", user, slog.String("method", randomOf("password", "sso", "token")))
+		default:
+			l.log(ctx, level, "payment processed", "", user, slog.String("provider", randomOf("stripe", "adyen", "paypal")))
+		}
+	case slog.LevelWarn:
+		switch rand.Intn(2) {
+		case 0:
+			l.log(ctx, level, "slow query", "", slog.String("table", randomOf("users", "orders", "payments")), slog.Duration("duration", time.Duration(500+rand.Intn(5000))*time.Millisecond))
+		default:
+			l.log(ctx, level, "retrying upstream request", "", slog.String("upstream", randomOf("inventory", "billing", "shipping")), slog.Int("attempt", 1+rand.Intn(3)))
+		}
+	default:
+		l.log(ctx, level, "failed to process payment", string(debug.Stack()), user,
+			slog.String("error", randomOf("card declined", "upstream timeout", "connection reset by peer")))
+	}
+}
+
+func randomOf(values ...string) string {
+	return values[rand.Intn(len(values))]
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(s))
+	return l, err
+}
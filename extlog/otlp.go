@@ -0,0 +1,37 @@
+package extlog
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/bridges/otelslog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// newOTLPHandler returns a handler exporting records with OTLP/HTTP in batches, and a function
+// flushing and shutting down the exporter. The OpenTelemetry bridge sets the trace and span ID
+// of records from OpenTelemetry spans in their context.
+func newOTLPHandler(endpoint, serviceName string) (slog.Handler, func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "parse OTLP endpoint %v", endpoint)
+	}
+	// The exporter posts to the path of the URL as is, default it like the metrics exporter.
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/logs"
+	}
+	exp, err := otlploghttp.New(context.Background(), otlploghttp.WithEndpointURL(u.String()))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create OTLP log exporter")
+	}
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exp)),
+		sdklog.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	return otelslog.NewHandler("github.com/saswatamcode/pingpong/extlog", otelslog.WithLoggerProvider(provider)), provider.Shutdown, nil
+}
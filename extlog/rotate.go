@@ -0,0 +1,85 @@
+package extlog
+
+import (
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RotatingFile is a file rotated when it exceeds a maximum size, keeping a number of rotated
+// files as <path>.1 (the newest) to <path>.<n>.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mtx  sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the file at path for appending. It is rotated before writes that
+// would exceed maxSize, zero means no limit. maxFiles rotated files are kept.
+func OpenRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open(flag int) error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|flag, 0o644)
+	if err != nil {
+		return errors.Wrapf(err, "open log file %v", r.path)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "stat log file %v", r.path)
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would exceed the maximum size.
+// Each write ends up in a single file.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return errors.Wrapf(err, "close log file %v", r.path)
+	}
+	if r.maxFiles > 0 {
+		// Shift <path>.i to <path>.i+1, dropping the oldest.
+		_ = os.Remove(r.path + "." + strconv.Itoa(r.maxFiles))
+		for i := r.maxFiles - 1; i >= 1; i-- {
+			_ = os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return errors.Wrapf(err, "rotate log file %v", r.path)
+		}
+	}
+	return r.open(os.O_TRUNC)
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.f.Close()
+}
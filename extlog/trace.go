@@ -0,0 +1,45 @@
+package extlog
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/saswatamcode/pingpong/exthttp"
+)
+
+// traceHandler adds the IDs of the span in the context of records.
+type traceHandler struct {
+	slog.Handler
+}
+
+// NewTraceHandler wraps h, adding trace_id and span_id attributes to records logged with a
+// context holding a span, e.g. with slog.InfoContext. Records that already have a trace_id,
+// e.g. access logs, are left as is.
+func NewTraceHandler(h slog.Handler) slog.Handler {
+	return traceHandler{Handler: h}
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if traceID, spanID, _ := exthttp.SpanIDs(ctx); traceID != "" && !hasTraceID(r) {
+		r = r.Clone()
+		r.AddAttrs(slog.String("trace_id", traceID), slog.String("span_id", spanID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func hasTraceID(r slog.Record) bool {
+	found := false
+	r.Attrs(func(a slog.Attr) bool {
+		found = a.Key == "trace_id"
+		return !found
+	})
+	return found
+}
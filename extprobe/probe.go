@@ -0,0 +1,158 @@
+// Package extprobe serves liveness, readiness and startup probes, e.g. for Kubernetes,
+// with injectable failures to demo restarts and the removal of pods from endpoints.
+package extprobe
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	ProbeLiveness  = "liveness"
+	ProbeReadiness = "readiness"
+	ProbeStartup   = "startup"
+)
+
+// Opts configures a Prober.
+type Opts struct {
+	// StartupDelay is how long the startup and readiness probes fail after start,
+	// simulating a slow startup.
+	StartupDelay time.Duration
+
+	// LivenessFailAfter is how long after start the liveness probe starts failing,
+	// simulating a deadlock. Zero means never.
+	LivenessFailAfter time.Duration
+
+	// ReadinessFlapInterval is how often the readiness probe alternates between passing and
+	// failing once started. Zero disables flapping.
+	ReadinessFlapInterval time.Duration
+}
+
+// Check returns an error if a dependency is not ready.
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Prober evaluates probes.
+type Prober struct {
+	opts         Opts
+	start        time.Time
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+
+	checksTotal *prometheus.CounterVec
+}
+
+// New creates a Prober started now and registers its metrics.
+func New(reg prometheus.Registerer, opts Opts) *Prober {
+	p := &Prober{
+		opts:  opts,
+		start: time.Now(),
+
+		checksTotal: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "health_probe_checks_total",
+			Help: "Total number of probe requests by result.",
+		}, []string{"probe", "result"}), // result: success, failure
+	}
+	for probe, f := range map[string]func() error{
+		ProbeLiveness:  p.Liveness,
+		ProbeReadiness: p.Readiness,
+		ProbeStartup:   p.Startup,
+	} {
+		promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "health_probe_success",
+			Help:        "Whether the probe currently passes.",
+			ConstLabels: prometheus.Labels{"probe": probe},
+		}, func() float64 {
+			if f() != nil {
+				return 0
+			}
+			return 1
+		})
+	}
+	return p
+}
+
+// AddReadinessCheck adds a check the readiness probe fails on. It must not be called
+// concurrently with probes.
+func (p *Prober) AddReadinessCheck(name string, check Check) {
+	p.checks = append(p.checks, namedCheck{name: name, check: check})
+}
+
+// ShutDown fails the readiness probe from now on.
+func (p *Prober) ShutDown() {
+	p.shuttingDown.Store(true)
+}
+
+// Liveness returns an error once the injected liveness failure started.
+func (p *Prober) Liveness() error {
+	if p.opts.LivenessFailAfter > 0 && time.Since(p.start) >= p.opts.LivenessFailAfter {
+		return errors.Errorf("injected liveness failure after %v", p.opts.LivenessFailAfter)
+	}
+	return nil
+}
+
+// Startup returns an error until the startup delay passed.
+func (p *Prober) Startup() error {
+	if time.Since(p.start) < p.opts.StartupDelay {
+		return errors.New("starting up")
+	}
+	return nil
+}
+
+// Readiness returns an error while starting up, shutting down, flapping to failing, or if
+// a readiness check fails.
+func (p *Prober) Readiness() error {
+	if p.shuttingDown.Load() {
+		return errors.New("shutting down")
+	}
+	if err := p.Startup(); err != nil {
+		return err
+	}
+	if i := p.opts.ReadinessFlapInterval; i > 0 && ((time.Since(p.start)-p.opts.StartupDelay)/i)%2 == 1 {
+		return errors.Errorf("injected readiness flap every %v", i)
+	}
+	for _, c := range p.checks {
+		if err := c.check(); err != nil {
+			return errors.Wrap(err, c.name)
+		}
+	}
+	return nil
+}
+
+// LivenessHandler serves the liveness probe.
+func (p *Prober) LivenessHandler() http.Handler {
+	return p.handler(ProbeLiveness, p.Liveness)
+}
+
+// ReadinessHandler serves the readiness probe.
+func (p *Prober) ReadinessHandler() http.Handler {
+	return p.handler(ProbeReadiness, p.Readiness)
+}
+
+// StartupHandler serves the startup probe.
+func (p *Prober) StartupHandler() http.Handler {
+	return p.handler(ProbeStartup, p.Startup)
+}
+
+// handler responds 200 if the probe passes and 503 with the error otherwise.
+func (p *Prober) handler(probe string, f func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err := f(); err != nil {
+			p.checksTotal.WithLabelValues(probe, "failure").Inc()
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		p.checksTotal.WithLabelValues(probe, "success").Inc()
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
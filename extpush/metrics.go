@@ -0,0 +1,78 @@
+package extpush
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Exporters, recorded in the exporter label of push metrics.
+const (
+	ExporterRemoteWrite = "remote_write"
+	ExporterOTLP        = "otlp"
+)
+
+// Metrics holds metrics push exporter metrics.
+type Metrics struct {
+	pushes      *prometheus.CounterVec
+	retries     *prometheus.CounterVec
+	samples     *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	lastSuccess *prometheus.GaugeVec
+}
+
+// NewMetrics creates a new instance of push exporter Metrics.
+// It registers the metrics with the provided registerer.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	const maxBucketNumber = 256
+	const bucketFactor = 1.1
+
+	return &Metrics{
+		pushes: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "push",
+				Name:      "requests_total",
+				Help:      "Total number of metrics pushes, including all their retries.",
+			},
+			[]string{"exporter", "result"}, // result: success, failure
+		),
+
+		retries: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "push",
+				Name:      "retries_total",
+				Help:      "Total number of retried push attempts. Retries of the OTLP exporter are internal and not counted.",
+			},
+			[]string{"exporter"},
+		),
+
+		samples: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "push",
+				Name:      "samples_total",
+				Help:      "Total number of samples pushed.",
+			},
+			[]string{"exporter", "result"},
+		),
+
+		duration: promauto.With(reg).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem:                      "push",
+				Name:                           "duration_seconds",
+				Help:                           "Duration of metrics pushes, including all their retries.",
+				Buckets:                        []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+				NativeHistogramBucketFactor:    bucketFactor,
+				NativeHistogramMaxBucketNumber: maxBucketNumber,
+			},
+			[]string{"exporter"},
+		),
+
+		lastSuccess: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Subsystem: "push",
+				Name:      "last_success_timestamp_seconds",
+				Help:      "Unix timestamp of the last successful push.",
+			},
+			[]string{"exporter"},
+		),
+	}
+}
@@ -0,0 +1,199 @@
+package extpush
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promBridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// OTLP protocols.
+const (
+	ProtocolHTTP = "http"
+	ProtocolGRPC = "grpc"
+)
+
+// OTLPOpts configures an OTLPExporter.
+type OTLPOpts struct {
+	// URL is the OTLP endpoint, e.g. http://localhost:4318 for HTTP or http://localhost:4317
+	// for gRPC. An http scheme disables TLS. HTTP URLs without a path post to /v1/metrics.
+	URL string
+
+	// Protocol is the OTLP protocol, one of ProtocolHTTP or ProtocolGRPC.
+	Protocol string
+
+	// Interval is the interval metrics are pushed in.
+	Interval time.Duration
+
+	// Timeout is the timeout of a push, including its retries.
+	Timeout time.Duration
+
+	// ExternalLabels are added as resource attributes.
+	ExternalLabels map[string]string
+
+	// Headers are added to all requests, e.g. for authentication or tenancy.
+	Headers map[string]string
+
+	// Retry configures retries, MaxRetries is approximated by the time the retries may take.
+	Retry RetryOpts
+}
+
+// OTLPExporter pushes metrics with OTLP, converting them with the OpenTelemetry Prometheus bridge.
+type OTLPExporter struct {
+	opts     OTLPOpts
+	provider *sdkmetric.MeterProvider
+}
+
+// NewOTLPExporter creates an OTLPExporter pushing the metrics of gatherer.
+// The service.name resource attribute defaults to serviceName, unless set as external label.
+func NewOTLPExporter(ctx context.Context, gatherer prometheus.Gatherer, metrics *Metrics, serviceName string, opts OTLPOpts) (*OTLPExporter, error) {
+	if opts.Interval <= 0 {
+		return nil, errors.Errorf("push interval has to be positive, got %v", opts.Interval)
+	}
+	if opts.Timeout <= 0 {
+		return nil, errors.Errorf("push timeout has to be positive, got %v", opts.Timeout)
+	}
+
+	// The exporter retries for at most the time MaxRetries retries would back off.
+	var maxElapsed time.Duration
+	for retry := 1; retry <= opts.Retry.MaxRetries; retry++ {
+		maxElapsed += opts.Retry.backoff(retry)
+	}
+	enabled := opts.Retry.MaxRetries > 0
+
+	var (
+		exp sdkmetric.Exporter
+		err error
+	)
+	switch opts.Protocol {
+	case ProtocolHTTP:
+		var u *url.URL
+		if u, err = url.Parse(opts.URL); err != nil {
+			return nil, errors.Wrapf(err, "parse OTLP URL %v", opts.URL)
+		}
+		// The exporter posts to the path of the URL as is, default it to the OTLP metrics path.
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/metrics"
+		}
+		exp, err = otlpmetrichttp.New(ctx,
+			otlpmetrichttp.WithEndpointURL(u.String()),
+			otlpmetrichttp.WithHeaders(opts.Headers),
+			otlpmetrichttp.WithTimeout(opts.Timeout),
+			otlpmetrichttp.WithRetry(otlpmetrichttp.RetryConfig{
+				Enabled:         enabled,
+				InitialInterval: opts.Retry.MinBackoff,
+				MaxInterval:     opts.Retry.MaxBackoff,
+				MaxElapsedTime:  maxElapsed,
+			}),
+		)
+	case ProtocolGRPC:
+		exp, err = otlpmetricgrpc.New(ctx,
+			otlpmetricgrpc.WithEndpointURL(opts.URL),
+			otlpmetricgrpc.WithHeaders(opts.Headers),
+			otlpmetricgrpc.WithTimeout(opts.Timeout),
+			otlpmetricgrpc.WithRetry(otlpmetricgrpc.RetryConfig{
+				Enabled:         enabled,
+				InitialInterval: opts.Retry.MinBackoff,
+				MaxInterval:     opts.Retry.MaxBackoff,
+				MaxElapsedTime:  maxElapsed,
+			}),
+		)
+	default:
+		return nil, errors.Errorf("unknown OTLP protocol %q, expected one of: %s, %s", opts.Protocol, ProtocolHTTP, ProtocolGRPC)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "create OTLP exporter")
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("service.name", serviceName)}
+	for k, v := range opts.ExternalLabels {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	reader := sdkmetric.NewPeriodicReader(
+		&instrumentedExporter{Exporter: exp, metrics: metrics},
+		sdkmetric.WithInterval(opts.Interval),
+		sdkmetric.WithTimeout(opts.Timeout),
+		sdkmetric.WithProducer(promBridge.NewMetricProducer(promBridge.WithGatherer(gatherer))),
+	)
+	return &OTLPExporter{
+		opts: opts,
+		provider: sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(reader),
+			// Later attributes win, so external labels override the service name.
+			sdkmetric.WithResource(resource.NewSchemaless(attrs...)),
+		),
+	}, nil
+}
+
+// Run pushes metrics every interval until ctx is canceled, then pushes them one last time so
+// metrics of short-lived processes are not lost.
+func (e *OTLPExporter) Run(ctx context.Context) error {
+	slog.Info("pushing metrics with OTLP", "url", e.opts.URL, "protocol", e.opts.Protocol, "interval", e.opts.Interval)
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), e.opts.Timeout)
+	defer cancel()
+	if err := e.provider.Shutdown(shutdownCtx); err != nil {
+		slog.Error("final OTLP push failed", "error", err)
+	}
+	return nil
+}
+
+// instrumentedExporter records push metrics of an OTLP exporter.
+type instrumentedExporter struct {
+	sdkmetric.Exporter
+	metrics *Metrics
+}
+
+func (e *instrumentedExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	start := time.Now()
+	err := e.Exporter.Export(ctx, rm)
+	e.metrics.duration.WithLabelValues(ExporterOTLP).Observe(time.Since(start).Seconds())
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+		slog.Error("OTLP push failed", "error", err)
+	} else {
+		e.metrics.lastSuccess.WithLabelValues(ExporterOTLP).SetToCurrentTime()
+	}
+	e.metrics.pushes.WithLabelValues(ExporterOTLP, result).Inc()
+	e.metrics.samples.WithLabelValues(ExporterOTLP, result).Add(float64(dataPoints(rm)))
+	return err
+}
+
+// dataPoints counts the data points of the pushed metrics.
+func dataPoints(rm *metricdata.ResourceMetrics) int {
+	n := 0
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch d := m.Data.(type) {
+			case metricdata.Gauge[float64]:
+				n += len(d.DataPoints)
+			case metricdata.Gauge[int64]:
+				n += len(d.DataPoints)
+			case metricdata.Sum[float64]:
+				n += len(d.DataPoints)
+			case metricdata.Sum[int64]:
+				n += len(d.DataPoints)
+			case metricdata.Histogram[float64]:
+				n += len(d.DataPoints)
+			case metricdata.ExponentialHistogram[float64]:
+				n += len(d.DataPoints)
+			case metricdata.Summary:
+				n += len(d.DataPoints)
+			}
+		}
+	}
+	return n
+}
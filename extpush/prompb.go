@@ -0,0 +1,217 @@
+package extpush
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Label is a label of a remote-write time series.
+type Label struct {
+	Name, Value string
+}
+
+// Sample is a sample of a remote-write time series, with the timestamp in milliseconds.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries is a remote-write time series.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// EncodeWriteRequest encodes time series as a Prometheus remote-write 1.0 WriteRequest protobuf.
+// It is encoded by hand to avoid depending on the Prometheus module for prompb.
+func EncodeWriteRequest(series []TimeSeries) []byte {
+	var b []byte
+	for _, ts := range series {
+		var tsb []byte
+		for _, l := range ts.Labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Value)
+
+			tsb = protowire.AppendTag(tsb, 1, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, lb)
+		}
+		for _, s := range ts.Samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+
+			tsb = protowire.AppendTag(tsb, 2, protowire.BytesType)
+			tsb = protowire.AppendBytes(tsb, sb)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, tsb)
+	}
+	return b
+}
+
+// DecodeWriteRequest decodes a Prometheus remote-write 1.0 WriteRequest protobuf.
+// Fields other than time series labels and samples, like metadata, are skipped.
+func DecodeWriteRequest(b []byte) ([]TimeSeries, error) {
+	var series []TimeSeries
+	err := decodeFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		var ts TimeSeries
+		err := decodeFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+			switch num {
+			case 1:
+				var l Label
+				err := decodeFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+					switch num {
+					case 1:
+						l.Name = string(v)
+					case 2:
+						l.Value = string(v)
+					}
+					return nil
+				})
+				ts.Labels = append(ts.Labels, l)
+				return err
+			case 2:
+				var s Sample
+				err := decodeFields(v, func(num protowire.Number, _ []byte, n uint64) error {
+					switch num {
+					case 1:
+						s.Value = math.Float64frombits(n)
+					case 2:
+						s.Timestamp = int64(n)
+					}
+					return nil
+				})
+				ts.Samples = append(ts.Samples, s)
+				return err
+			}
+			return nil
+		})
+		series = append(series, ts)
+		return err
+	})
+	return series, err
+}
+
+// decodeFields calls fn for every field of a protobuf message, with the bytes of
+// length-delimited fields or the number of varint and fixed fields.
+func decodeFields(b []byte, fn func(num protowire.Number, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return errors.Wrap(protowire.ParseError(l), "parse tag")
+		}
+		b = b[l:]
+
+		var (
+			v []byte
+			n uint64
+		)
+		switch typ {
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			n, l = protowire.ConsumeFixed64(b)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return errors.Wrapf(protowire.ParseError(l), "parse field %d", num)
+		}
+		b = b[l:]
+		if err := fn(num, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toTimeSeries converts gathered metric families to time series like Prometheus would scrape
+// them, adding the external labels unless a series has a label of the same name.
+// Native histograms are converted to their classic buckets, if any.
+func toTimeSeries(mfs []*dto.MetricFamily, externalLabels map[string]string, now time.Time) []TimeSeries {
+	var series []TimeSeries
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			ts := now.UnixMilli()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, v float64, extra ...string) {
+				labels := make([]Label, 0, len(m.GetLabel())+len(externalLabels)+2)
+				labels = append(labels, Label{Name: "__name__", Value: name + suffix})
+				seen := map[string]struct{}{}
+				for _, l := range m.GetLabel() {
+					labels = append(labels, Label{Name: l.GetName(), Value: l.GetValue()})
+					seen[l.GetName()] = struct{}{}
+				}
+				for i := 0; i+1 < len(extra); i += 2 {
+					labels = append(labels, Label{Name: extra[i], Value: extra[i+1]})
+					seen[extra[i]] = struct{}{}
+				}
+				for k, v := range externalLabels {
+					if _, ok := seen[k]; !ok {
+						labels = append(labels, Label{Name: k, Value: v})
+					}
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+				series = append(series, TimeSeries{Labels: labels, Samples: []Sample{{Value: v, Timestamp: ts}}})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), "quantile", FormatFloat(q.GetQuantile()))
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						continue
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), "le", FormatFloat(b.GetUpperBound()))
+				}
+				add("_bucket", float64(h.GetSampleCount()), "le", "+Inf")
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+// FormatFloat formats the value of an le or quantile label like Prometheus 3 does when scraping,
+// with at least one decimal, e.g. 1 as "1.0", so pushed and scraped series match.
+func FormatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !math.IsInf(f, 0) && !math.IsNaN(f) && !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,85 @@
+package extpush
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+func TestWriteRequestRoundTrip(t *testing.T) {
+	series := []TimeSeries{
+		{
+			Labels: []Label{
+				{Name: "__name__", Value: "http_requests_total"},
+				{Name: "code", Value: "200"},
+				{Name: "job", Value: "ci"},
+			},
+			Samples: []Sample{
+				{Value: 42, Timestamp: 1700000000000},
+				{Value: 43.5, Timestamp: 1700000015000},
+			},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "temperature"}, {Name: "empty", Value: ""}},
+			Samples: []Sample{{Value: -1.25, Timestamp: -1}, {Value: math.Inf(1)}},
+		},
+	}
+
+	compressed := snappy.Encode(nil, EncodeWriteRequest(series))
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Fatalf("decode snappy: %v", err)
+	}
+	got, err := DecodeWriteRequest(b)
+	if err != nil {
+		t.Fatalf("decode write request: %v", err)
+	}
+	if !reflect.DeepEqual(got, series) {
+		t.Errorf("round trip mismatch:\ngot:  %+v\nwant: %+v", got, series)
+	}
+}
+
+func TestWriteRequestGolden(t *testing.T) {
+	series := []TimeSeries{{
+		Labels:  []Label{{Name: "__name__", Value: "up"}},
+		Samples: []Sample{{Value: 1, Timestamp: 1000}},
+	}}
+	// WriteRequest{timeseries: [{labels: [{name: "__name__", value: "up"}], samples: [{value: 1, timestamp: 1000}]}]}
+	// as encoded by the Prometheus prompb package.
+	golden := mustDecodeHex(t, "0a1e"+ // timeseries, 30 bytes
+		"0a0e"+"0a085f5f6e616d655f5f"+"12027570"+ // label, 14 bytes: name "__name__", value "up"
+		"120c"+"09000000000000f03f"+"10e807") // sample, 12 bytes: value 1, timestamp 1000
+
+	if got := EncodeWriteRequest(series); !bytes.Equal(got, golden) {
+		t.Errorf("encode mismatch:\ngot:  %x\nwant: %x", got, golden)
+	}
+
+	// Metadata, field 3 of WriteRequest, of type counter for "up" is skipped.
+	withMetadata := append(append([]byte{}, golden...), mustDecodeHex(t, "1a06080112027570")...)
+	for name, b := range map[string][]byte{"golden": golden, "with metadata": withMetadata} {
+		got, err := DecodeWriteRequest(b)
+		if err != nil {
+			t.Fatalf("%s: decode write request: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, series) {
+			t.Errorf("%s: decode mismatch:\ngot:  %+v\nwant: %+v", name, got, series)
+		}
+	}
+
+	if _, err := DecodeWriteRequest(golden[:len(golden)-1]); err == nil {
+		t.Error("expected error decoding truncated write request")
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decode hex %q: %v", s, err)
+	}
+	return b
+}
@@ -0,0 +1,181 @@
+// Package extpush pushes metrics of a Prometheus registry to Prometheus remote-write and OTLP
+// receivers, for processes nothing scrapes, like ephemeral CI jobs.
+package extpush
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetryOpts configures retries of failed pushes with exponential backoff.
+type RetryOpts struct {
+	// MaxRetries is the number of retries of a failed push before it is dropped.
+	MaxRetries int
+
+	// MinBackoff is the backoff before the first retry, doubled for every further retry.
+	MinBackoff time.Duration
+
+	// MaxBackoff caps the backoff between retries.
+	MaxBackoff time.Duration
+}
+
+// backoff returns the backoff before the given retry, starting at 1.
+func (o RetryOpts) backoff(retry int) time.Duration {
+	b := o.MinBackoff
+	for i := 1; i < retry && b < o.MaxBackoff; i++ {
+		b *= 2
+	}
+	return min(b, o.MaxBackoff)
+}
+
+// RemoteWriteOpts configures a RemoteWriter.
+type RemoteWriteOpts struct {
+	// URL is the remote-write endpoint, e.g. http://localhost:9090/api/v1/write.
+	URL string
+
+	// Interval is the interval metrics are pushed in.
+	Interval time.Duration
+
+	// Timeout is the timeout of a single push attempt.
+	Timeout time.Duration
+
+	// ExternalLabels are added to all series, unless a series has a label of the same name.
+	ExternalLabels map[string]string
+
+	// Headers are added to all requests, e.g. for authentication or tenancy.
+	Headers map[string]string
+
+	Retry RetryOpts
+}
+
+// RemoteWriter pushes metrics with the Prometheus remote-write 1.0 protocol.
+type RemoteWriter struct {
+	gatherer prometheus.Gatherer
+	metrics  *Metrics
+	opts     RemoteWriteOpts
+	client   *http.Client
+}
+
+// NewRemoteWriter creates a RemoteWriter pushing the metrics of gatherer.
+func NewRemoteWriter(gatherer prometheus.Gatherer, metrics *Metrics, opts RemoteWriteOpts) (*RemoteWriter, error) {
+	if opts.Interval <= 0 {
+		return nil, errors.Errorf("push interval has to be positive, got %v", opts.Interval)
+	}
+	if opts.Timeout <= 0 {
+		return nil, errors.Errorf("push timeout has to be positive, got %v", opts.Timeout)
+	}
+	return &RemoteWriter{
+		gatherer: gatherer,
+		metrics:  metrics,
+		opts:     opts,
+		client:   &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// Run pushes metrics every interval until ctx is canceled, then pushes them one last time so
+// metrics of short-lived processes are not lost.
+func (w *RemoteWriter) Run(ctx context.Context) error {
+	slog.Info("pushing metrics with remote-write", "url", w.opts.URL, "interval", w.opts.Interval)
+
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), w.opts.Timeout)
+			defer cancel()
+			if err := w.Push(flushCtx); err != nil {
+				slog.Error("final remote-write push failed", "error", err)
+			}
+			return nil
+		case <-ticker.C:
+			if err := w.Push(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("remote-write push failed", "error", err)
+			}
+		}
+	}
+}
+
+// Push gathers and pushes metrics once, retrying failed attempts.
+func (w *RemoteWriter) Push(ctx context.Context) error {
+	start := time.Now()
+	mfs, err := w.gatherer.Gather()
+	if err != nil {
+		// Gather returns as many metrics as possible on errors.
+		slog.Warn("gather metrics for remote-write", "error", err)
+	}
+	series := toTimeSeries(mfs, w.opts.ExternalLabels, start)
+	body := snappy.Encode(nil, EncodeWriteRequest(series))
+
+	err = w.send(ctx, body)
+	w.metrics.duration.WithLabelValues(ExporterRemoteWrite).Observe(time.Since(start).Seconds())
+	if err != nil {
+		w.metrics.pushes.WithLabelValues(ExporterRemoteWrite, "failure").Inc()
+		w.metrics.samples.WithLabelValues(ExporterRemoteWrite, "failure").Add(float64(len(series)))
+		return err
+	}
+	w.metrics.pushes.WithLabelValues(ExporterRemoteWrite, "success").Inc()
+	w.metrics.samples.WithLabelValues(ExporterRemoteWrite, "success").Add(float64(len(series)))
+	w.metrics.lastSuccess.WithLabelValues(ExporterRemoteWrite).SetToCurrentTime()
+	slog.Debug("pushed metrics with remote-write", "series", len(series), "bytes", len(body))
+	return nil
+}
+
+// send posts the request body, retrying network errors, 5xx and 429 responses.
+func (w *RemoteWriter) send(ctx context.Context, body []byte) error {
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			w.metrics.retries.WithLabelValues(ExporterRemoteWrite).Inc()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(w.opts.Retry.backoff(retry)):
+			}
+		}
+
+		recoverable, err := w.attempt(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !recoverable || retry >= w.opts.Retry.MaxRetries || ctx.Err() != nil {
+			return errors.Wrapf(err, "push after %d retries", retry)
+		}
+		slog.Debug("retrying remote-write push", "retry", retry+1, "error", err)
+	}
+}
+
+func (w *RemoteWriter) attempt(ctx context.Context, body []byte) (recoverable bool, _ error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "create request")
+	}
+	for k, v := range w.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "pingpong")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err = errors.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	return resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests, err
+}
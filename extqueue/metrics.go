@@ -0,0 +1,179 @@
+package extqueue
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics holds message queue metrics.
+type Metrics struct {
+	published          *prometheus.CounterVec
+	consumed           *prometheus.CounterVec
+	redeliveries       prometheus.Counter
+	deadLetters        prometheus.Counter
+	depth              prometheus.Gauge
+	deadLetterDepth    prometheus.Gauge
+	capacity           prometheus.Gauge
+	consumerLag        prometheus.Gauge
+	consumersBusy      prometheus.Gauge
+	waitDuration       prometheus.Histogram
+	processingDuration *prometheus.HistogramVec
+}
+
+// NewMetrics creates a new instance of message queue Metrics.
+// It registers the metrics with the provided registerer.
+func NewMetrics(reg prometheus.Registerer, durationBuckets []float64) *Metrics {
+	if durationBuckets == nil {
+		durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	}
+
+	const maxBucketNumber = 256
+	const bucketFactor = 1.1
+
+	m := &Metrics{
+		published: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "queue",
+				Name:      "messages_published_total",
+				Help:      "Total number of messages published to the queue.",
+			},
+			[]string{"result"}, // success, rejected
+		),
+
+		consumed: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Subsystem: "queue",
+				Name:      "messages_consumed_total",
+				Help:      "Total number of message deliveries processed by consumers.",
+			},
+			[]string{"result"}, // acked, failed
+		),
+
+		redeliveries: promauto.With(reg).NewCounter(
+			prometheus.CounterOpts{
+				Subsystem: "queue",
+				Name:      "redeliveries_total",
+				Help:      "Total number of failed messages redelivered.",
+			},
+		),
+
+		deadLetters: promauto.With(reg).NewCounter(
+			prometheus.CounterOpts{
+				Subsystem: "queue",
+				Name:      "dead_letters_total",
+				Help:      "Total number of messages moved to the dead-letter queue after exhausting their deliveries.",
+			},
+		),
+
+		depth: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "queue",
+				Name:      "depth",
+				Help:      "Current number of messages waiting to be consumed, including ones waiting for redelivery.",
+			},
+		),
+
+		deadLetterDepth: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "queue",
+				Name:      "dead_letter_depth",
+				Help:      "Current number of messages in the dead-letter queue.",
+			},
+		),
+
+		capacity: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "queue",
+				Name:      "capacity",
+				Help:      "Maximum number of messages waiting to be consumed, further messages are rejected.",
+			},
+		),
+
+		consumerLag: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "queue",
+				Name:      "consumer_lag_seconds",
+				Help:      "Age of the oldest message waiting to be consumed, 0 if the queue is empty.",
+			},
+		),
+
+		consumersBusy: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "queue",
+				Name:      "consumers_busy",
+				Help:      "Current number of consumers processing a message.",
+			},
+		),
+
+		waitDuration: promauto.With(reg).NewHistogram(
+			prometheus.HistogramOpts{
+				Subsystem:                      "queue",
+				Name:                           "message_wait_seconds",
+				Help:                           "Histogram of time messages waited in the queue before being delivered.",
+				Buckets:                        durationBuckets,
+				NativeHistogramBucketFactor:    bucketFactor,
+				NativeHistogramMaxBucketNumber: maxBucketNumber,
+			},
+		),
+
+		processingDuration: promauto.With(reg).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem:                      "queue",
+				Name:                           "processing_duration_seconds",
+				Help:                           "Histogram of message processing durations.",
+				Buckets:                        durationBuckets,
+				NativeHistogramBucketFactor:    bucketFactor,
+				NativeHistogramMaxBucketNumber: maxBucketNumber,
+			},
+			[]string{"result"},
+		),
+	}
+	for _, r := range []string{"success", "rejected"} {
+		m.published.WithLabelValues(r)
+	}
+	for _, r := range []string{"acked", "failed"} {
+		m.consumed.WithLabelValues(r)
+	}
+	return m
+}
+
+// RecordPublish records a published message with its result.
+func (m *Metrics) RecordPublish(result string) {
+	m.published.WithLabelValues(result).Inc()
+}
+
+// RecordConsume records a processed delivery with its result, time waited in the queue
+// and processing duration in seconds.
+func (m *Metrics) RecordConsume(result string, wait, duration float64) {
+	m.consumed.WithLabelValues(result).Inc()
+	m.waitDuration.Observe(wait)
+	m.processingDuration.WithLabelValues(result).Observe(duration)
+}
+
+// IncRedeliveries increments the redeliveries counter.
+func (m *Metrics) IncRedeliveries() {
+	m.redeliveries.Inc()
+}
+
+// RecordDeadLetter records a message moved to the dead-letter queue, which now holds depth messages.
+func (m *Metrics) RecordDeadLetter(depth float64) {
+	m.deadLetters.Inc()
+	m.deadLetterDepth.Set(depth)
+}
+
+// SetDepth sets the queue depth, capacity and consumer lag in seconds.
+func (m *Metrics) SetDepth(depth, capacity, lag float64) {
+	m.depth.Set(depth)
+	m.capacity.Set(capacity)
+	m.consumerLag.Set(lag)
+}
+
+// IncConsumersBusy increments the busy consumers gauge.
+func (m *Metrics) IncConsumersBusy() {
+	m.consumersBusy.Inc()
+}
+
+// DecConsumersBusy decrements the busy consumers gauge.
+func (m *Metrics) DecConsumersBusy() {
+	m.consumersBusy.Dec()
+}
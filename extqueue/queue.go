@@ -0,0 +1,252 @@
+// Package extqueue simulates an in-process message broker with a pool of consumers,
+// so asynchronous pipelines can be demoed next to synchronous requests. Consumers take
+// configurable time to process messages and fail with a configurable probability, failed
+// messages are redelivered until they exhaust their deliveries and are dead-lettered.
+package extqueue
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/saswatamcode/pingpong/extrand"
+)
+
+// ErrQueueFull is returned when publishing to a queue holding its capacity of messages.
+var ErrQueueFull = errors.New("queue full")
+
+// Opts configures the queue simulator.
+type Opts struct {
+	// Capacity is the maximum number of messages waiting to be consumed.
+	// Publishing beyond it fails with ErrQueueFull.
+	Capacity int
+
+	// Consumers is the number of messages processed concurrently.
+	Consumers int
+
+	// ProcessingLatency is the encoded processing latency and probability in format:
+	// <probability>%<duration>,<probability>%<duration>...
+	ProcessingLatency string
+
+	// SuccessProb is the probability (in %) of a message being processed successfully (0-100).
+	SuccessProb float64
+
+	// MaxDeliveries is how often a message is delivered before it is dead-lettered.
+	MaxDeliveries int
+
+	// RedeliveryDelay is how long a failed message waits before it is delivered again.
+	RedeliveryDelay time.Duration
+}
+
+// DefaultOpts returns default queue options.
+func DefaultOpts() Opts {
+	return Opts{
+		Capacity:          1000,
+		Consumers:         1,
+		ProcessingLatency: "90%20ms,10%200ms",
+		SuccessProb:       99,
+		MaxDeliveries:     3,
+		RedeliveryDelay:   time.Second,
+	}
+}
+
+// Message is a simulated message.
+type Message struct {
+	ID          uint64
+	PublishedAt time.Time
+	// Deliveries is the number of times the message was delivered to a consumer.
+	Deliveries int
+
+	enqueuedAt time.Time
+}
+
+// Queue is a simulated message queue.
+type Queue struct {
+	metrics *Metrics
+	opts    Opts
+	latency *extrand.WeightedChoice[time.Duration]
+
+	mtx         sync.Mutex
+	nextID      uint64
+	pending     []*Message // Oldest first.
+	delayed     int        // Failed messages waiting for redelivery.
+	deadLetters int
+	ready       chan struct{}
+}
+
+// New creates a new queue simulator.
+func New(metrics *Metrics, opts Opts) (*Queue, error) {
+	if opts.Capacity <= 0 {
+		return nil, errors.Errorf("capacity has to be positive, got %v", opts.Capacity)
+	}
+	if opts.Consumers <= 0 {
+		return nil, errors.Errorf("consumers have to be positive, got %v", opts.Consumers)
+	}
+	if opts.MaxDeliveries <= 0 {
+		return nil, errors.Errorf("max deliveries have to be positive, got %v", opts.MaxDeliveries)
+	}
+	latency, err := extrand.NewWeightedChoice(opts.ProcessingLatency, time.ParseDuration)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing processing latency")
+	}
+
+	q := &Queue{
+		metrics: metrics,
+		opts:    opts,
+		latency: latency,
+		ready:   make(chan struct{}, 1),
+	}
+	q.updateMetricsLocked(time.Now())
+	return q, nil
+}
+
+// Publish enqueues a new message. It fails with ErrQueueFull if the queue is at capacity.
+func (q *Queue) Publish() (*Message, error) {
+	now := time.Now()
+
+	q.mtx.Lock()
+	if len(q.pending)+q.delayed >= q.opts.Capacity {
+		q.mtx.Unlock()
+		q.metrics.RecordPublish("rejected")
+		return nil, ErrQueueFull
+	}
+	q.nextID++
+	msg := &Message{ID: q.nextID, PublishedAt: now}
+	q.enqueueLocked(msg, now)
+	q.mtx.Unlock()
+
+	q.metrics.RecordPublish("success")
+	return msg, nil
+}
+
+// Run starts the consumers and blocks until the context is done.
+func (q *Queue) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for range q.opts.Consumers {
+		wg.Go(func() { q.consume(ctx) })
+	}
+
+	// Keep the consumer lag growing while no message is consumed.
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case now := <-t.C:
+			q.mtx.Lock()
+			q.updateMetricsLocked(now)
+			q.mtx.Unlock()
+		}
+	}
+}
+
+// consume processes messages until the context is done.
+func (q *Queue) consume(ctx context.Context) {
+	for {
+		msg := q.next()
+		if msg == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.ready:
+			}
+			continue
+		}
+		if !q.process(ctx, msg) {
+			return
+		}
+	}
+}
+
+// next dequeues the oldest message, or returns nil if there is none.
+func (q *Queue) next() *Message {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil
+	}
+	msg := q.pending[0]
+	q.pending[0] = nil
+	q.pending = q.pending[1:]
+	if len(q.pending) > 0 {
+		// Wake up another consumer for the remaining messages.
+		q.signal()
+	}
+	q.updateMetricsLocked(time.Now())
+	return msg
+}
+
+// process simulates processing a delivery of msg. It returns false if the context was done.
+func (q *Queue) process(ctx context.Context, msg *Message) bool {
+	q.metrics.IncConsumersBusy()
+	defer q.metrics.DecConsumersBusy()
+
+	start := time.Now()
+	wait := start.Sub(msg.enqueuedAt)
+	msg.Deliveries++
+
+	latency, _ := q.latency.Pick()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(latency):
+	}
+
+	if rand.Float64()*100 <= q.opts.SuccessProb {
+		q.metrics.RecordConsume("acked", wait.Seconds(), time.Since(start).Seconds())
+		slog.Debug("simulated message processed", "id", msg.ID, "deliveries", msg.Deliveries, "age", time.Since(msg.PublishedAt))
+		return true
+	}
+	q.metrics.RecordConsume("failed", wait.Seconds(), time.Since(start).Seconds())
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if msg.Deliveries >= q.opts.MaxDeliveries {
+		q.deadLetters++
+		q.metrics.RecordDeadLetter(float64(q.deadLetters))
+		slog.Warn("simulated message dead-lettered", "id", msg.ID, "deliveries", msg.Deliveries)
+		return true
+	}
+
+	q.metrics.IncRedeliveries()
+	slog.Debug("simulated message failed, redelivering", "id", msg.ID, "deliveries", msg.Deliveries, "delay", q.opts.RedeliveryDelay)
+	q.delayed++
+	q.updateMetricsLocked(time.Now())
+	time.AfterFunc(q.opts.RedeliveryDelay, func() {
+		q.mtx.Lock()
+		defer q.mtx.Unlock()
+		q.delayed--
+		q.enqueueLocked(msg, time.Now())
+	})
+	return true
+}
+
+func (q *Queue) enqueueLocked(msg *Message, now time.Time) {
+	msg.enqueuedAt = now
+	q.pending = append(q.pending, msg)
+	q.signal()
+	q.updateMetricsLocked(now)
+}
+
+// signal wakes up a waiting consumer, if any.
+func (q *Queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) updateMetricsLocked(now time.Time) {
+	lag := 0.0
+	if len(q.pending) > 0 {
+		lag = now.Sub(q.pending[0].PublishedAt).Seconds()
+	}
+	q.metrics.SetDepth(float64(len(q.pending)+q.delayed), float64(q.opts.Capacity), lag)
+}
@@ -0,0 +1,88 @@
+// Package extrand picks values at random according to probabilities configured in the
+// <probability>%<value>,<probability>%<value>... flag format.
+package extrand
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WeightedChoice picks one of several values according to their configured probabilities.
+type WeightedChoice[T any] struct {
+	values     []T
+	cumulative []float64 // Cumulative probabilities, ascending.
+}
+
+// NewWeightedChoice parses choices encoded as <probability>%<value>,<probability>%<value>...
+// using parse for the values. Probabilities have to add up to 100.
+func NewWeightedChoice[T any](encoded string, parse func(string) (T, error)) (*WeightedChoice[T], error) {
+	c, err := NewPartialWeightedChoice(encoded, parse)
+	if err != nil {
+		return nil, err
+	}
+	// Allow for rounding errors of float sums such as 33.3+33.3+33.4.
+	if total := c.total(); math.Abs(total-100) > 1e-9 {
+		return nil, errors.Errorf("overall probability has to equal 100. Parsed input equals to %v", total)
+	}
+	return c, nil
+}
+
+// NewPartialWeightedChoice is like NewWeightedChoice, but probabilities may add up to less
+// than 100, in which case Pick reports no choice for the remainder.
+func NewPartialWeightedChoice[T any](encoded string, parse func(string) (T, error)) (*WeightedChoice[T], error) {
+	c := &WeightedChoice[T]{}
+
+	cumulativeProb := 0.0
+	for _, e := range strings.Split(encoded, ",") {
+		// Split on the first % only, values such as content types may contain it.
+		prob, value, ok := strings.Cut(strings.TrimSpace(e), "%")
+		if !ok {
+			return nil, errors.Errorf("invalid input %v", encoded)
+		}
+		f, err := strconv.ParseFloat(prob, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse probability %v as float", prob)
+		}
+		v, err := parse(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse value %v", value)
+		}
+		cumulativeProb += f
+		c.values = append(c.values, v)
+		c.cumulative = append(c.cumulative, cumulativeProb)
+	}
+	if cumulativeProb > 100+1e-9 {
+		return nil, errors.Errorf("overall probability cannot exceed 100. Parsed input equals to %v", cumulativeProb)
+	}
+	return c, nil
+}
+
+func (c *WeightedChoice[T]) total() float64 {
+	return c.cumulative[len(c.cumulative)-1]
+}
+
+// Values returns the configured values, e.g. for logging.
+func (c *WeightedChoice[T]) Values() []T {
+	return c.values
+}
+
+// Pick returns a randomly chosen value. It returns false if the roll fell
+// into the remainder of a partial choice.
+func (c *WeightedChoice[T]) Pick() (T, bool) {
+	n := rand.Float64() * 100
+	for i, p := range c.cumulative {
+		if n < p {
+			return c.values[i], true
+		}
+	}
+	// Rolls beyond a total that is short of 100 by a rounding error pick the last value.
+	if c.total() >= 100-1e-9 {
+		return c.values[len(c.values)-1], true
+	}
+	var zero T
+	return zero, false
+}
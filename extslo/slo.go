@@ -0,0 +1,307 @@
+// Package extslo tracks availability and latency SLOs of HTTP handlers over sliding windows,
+// exporting error budget and burn rate metrics without a Prometheus rule pipeline.
+package extslo
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/saswatamcode/pingpong/exthttp"
+)
+
+// SLOs tracked per handler.
+const (
+	// Availability is the ratio of requests not failing with a 5xx status.
+	Availability = "availability"
+	// Latency is the ratio of requests served faster than the latency threshold.
+	Latency = "latency"
+)
+
+// maxBuckets bounds the number of time buckets requests are counted in per handler.
+const maxBuckets = 720
+
+// Opts configures a Tracker.
+type Opts struct {
+	// AvailabilityTarget is the target ratio (in %) of requests not failing with 5xx.
+	AvailabilityTarget float64
+
+	// LatencyThreshold is the duration requests have to be served in to count as good.
+	LatencyThreshold time.Duration
+
+	// LatencyTarget is the target ratio (in %) of requests served faster than LatencyThreshold.
+	LatencyTarget float64
+
+	// Windows are the sliding windows burn rates are computed over.
+	Windows []time.Duration
+
+	// BudgetWindow is the sliding window the error budget is computed over.
+	BudgetWindow time.Duration
+}
+
+// Tracker tracks SLOs of HTTP handlers.
+type Tracker struct {
+	opts       Opts
+	resolution time.Duration
+	nBuckets   int
+
+	mtx      sync.Mutex
+	handlers map[string]*series
+
+	objectiveDesc *prometheus.Desc
+	sliDesc       *prometheus.Desc
+	burnRateDesc  *prometheus.Desc
+	budgetDesc    *prometheus.Desc
+}
+
+// New creates a Tracker and registers its metrics.
+func New(reg prometheus.Registerer, opts Opts) (*Tracker, error) {
+	for _, t := range []float64{opts.AvailabilityTarget, opts.LatencyTarget} {
+		if t <= 0 || t >= 100 {
+			return nil, errors.Errorf("SLO targets have to be between 0 and 100 exclusive, got %v", t)
+		}
+	}
+	if opts.BudgetWindow <= 0 {
+		return nil, errors.Errorf("budget window has to be positive, got %v", opts.BudgetWindow)
+	}
+	retention := opts.BudgetWindow
+	for _, w := range opts.Windows {
+		if w <= 0 {
+			return nil, errors.Errorf("windows have to be positive, got %v", w)
+		}
+		retention = max(retention, w)
+	}
+
+	resolution := max(time.Second, (retention / maxBuckets).Round(time.Second))
+	t := &Tracker{
+		opts:       opts,
+		resolution: resolution,
+		nBuckets:   int((retention+resolution-1)/resolution) + 1,
+		handlers:   map[string]*series{},
+
+		objectiveDesc: prometheus.NewDesc("slo_objective", "Target ratio of good requests of the SLO.", []string{"handler", "slo"}, nil),
+		sliDesc:       prometheus.NewDesc("slo_sli", "Ratio of good requests over the window. Absent without requests.", []string{"handler", "slo", "window"}, nil),
+		burnRateDesc:  prometheus.NewDesc("slo_burn_rate", "Rate the error budget is consumed with over the window, 1 exhausts it exactly at the end of the budget window.", []string{"handler", "slo", "window"}, nil),
+		budgetDesc:    prometheus.NewDesc("slo_error_budget_remaining", "Ratio of the error budget remaining over the budget window, negative if exceeded.", []string{"handler", "slo"}, nil),
+	}
+	if reg != nil {
+		if err := reg.Register(t); err != nil {
+			return nil, errors.Wrap(err, "registering SLO metrics")
+		}
+	}
+	return t, nil
+}
+
+// Handler wraps next, tracking the SLOs of its requests under handlerName.
+func (t *Tracker) Handler(handlerName string, next http.Handler) http.Handler {
+	s := t.series(handlerName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wd := exthttp.NewResponseWriterDelegator(w)
+
+		// Requests aborted by a panic count as failed.
+		completed := false
+		defer func() {
+			s.record(time.Now(), !completed || wd.StatusCode() >= 500, time.Since(start) > t.opts.LatencyThreshold)
+		}()
+		next.ServeHTTP(wd, r)
+		completed = true
+	})
+}
+
+func (t *Tracker) series(handlerName string) *series {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	s, ok := t.handlers[handlerName]
+	if !ok {
+		s = &series{resolution: t.resolution, buckets: make([]bucket, t.nBuckets)}
+		t.handlers[handlerName] = s
+	}
+	return s
+}
+
+// Report is the SLO report served by the Tracker.
+type Report struct {
+	BudgetWindow string          `json:"budget_window"`
+	Handlers     []HandlerReport `json:"handlers"`
+}
+
+// HandlerReport is the SLO report of a handler.
+type HandlerReport struct {
+	Handler string      `json:"handler"`
+	SLOs    []SLOReport `json:"slos"`
+}
+
+// SLOReport is the report of an SLO of a handler.
+type SLOReport struct {
+	SLO                  string         `json:"slo"`
+	Objective            float64        `json:"objective"`
+	ErrorBudgetRemaining float64        `json:"error_budget_remaining"`
+	Windows              []WindowReport `json:"windows"`
+}
+
+// WindowReport is the state of an SLO over a window. SLI is omitted without requests.
+type WindowReport struct {
+	Window   string   `json:"window"`
+	Total    uint64   `json:"total"`
+	Bad      uint64   `json:"bad"`
+	SLI      *float64 `json:"sli,omitempty"`
+	BurnRate float64  `json:"burn_rate"`
+}
+
+// Report returns the current state of all SLOs.
+func (t *Tracker) Report() Report {
+	now := time.Now()
+
+	t.mtx.Lock()
+	names := make([]string, 0, len(t.handlers))
+	for name := range t.handlers {
+		names = append(names, name)
+	}
+	t.mtx.Unlock()
+	sort.Strings(names)
+
+	windows := slices.Clone(t.opts.Windows)
+	slices.Sort(windows)
+
+	r := Report{BudgetWindow: model.Duration(t.opts.BudgetWindow).String()}
+	for _, name := range names {
+		s := t.series(name)
+		h := HandlerReport{Handler: name}
+		for _, slo := range []string{Availability, Latency} {
+			objective := t.objective(slo)
+			sr := SLOReport{SLO: slo, Objective: objective}
+
+			total, bad := s.sum(now, t.opts.BudgetWindow, slo)
+			sr.ErrorBudgetRemaining = 1 - burnRate(total, bad, objective)
+
+			for _, w := range windows {
+				total, bad := s.sum(now, w, slo)
+				wr := WindowReport{Window: model.Duration(w).String(), Total: total, Bad: bad, BurnRate: burnRate(total, bad, objective)}
+				if total > 0 {
+					sli := 1 - float64(bad)/float64(total)
+					wr.SLI = &sli
+				}
+				sr.Windows = append(sr.Windows, wr)
+			}
+			h.SLOs = append(h.SLOs, sr)
+		}
+		r.Handlers = append(r.Handlers, h)
+	}
+	return r
+}
+
+// ServeHTTP serves the SLO report as JSON.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(t.Report())
+}
+
+// Describe implements prometheus.Collector.
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.objectiveDesc
+	ch <- t.sliDesc
+	ch <- t.burnRateDesc
+	ch <- t.budgetDesc
+}
+
+// Collect implements prometheus.Collector. SLOs are computed on scrape.
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	for _, h := range t.Report().Handlers {
+		for _, slo := range h.SLOs {
+			ch <- prometheus.MustNewConstMetric(t.objectiveDesc, prometheus.GaugeValue, slo.Objective, h.Handler, slo.SLO)
+			ch <- prometheus.MustNewConstMetric(t.budgetDesc, prometheus.GaugeValue, slo.ErrorBudgetRemaining, h.Handler, slo.SLO)
+			for _, w := range slo.Windows {
+				ch <- prometheus.MustNewConstMetric(t.burnRateDesc, prometheus.GaugeValue, w.BurnRate, h.Handler, slo.SLO, w.Window)
+				if w.SLI != nil {
+					ch <- prometheus.MustNewConstMetric(t.sliDesc, prometheus.GaugeValue, *w.SLI, h.Handler, slo.SLO, w.Window)
+				}
+			}
+		}
+	}
+}
+
+func (t *Tracker) objective(slo string) float64 {
+	target := t.opts.LatencyTarget
+	if slo == Availability {
+		target = t.opts.AvailabilityTarget
+	}
+	return roundRatio(target / 100)
+}
+
+// burnRate returns the ratio of bad requests relative to the error budget, 0 without requests.
+func burnRate(total, bad uint64, objective float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return roundRatio(float64(bad) / float64(total) / (1 - objective))
+}
+
+// roundRatio rounds away floating point noise, e.g. 99.9/100 = 0.9990000000000001.
+func roundRatio(r float64) float64 {
+	return math.Round(r*1e9) / 1e9
+}
+
+// series counts requests of a handler in a ring of time buckets.
+type series struct {
+	resolution time.Duration
+
+	mtx     sync.Mutex
+	buckets []bucket
+}
+
+type bucket struct {
+	idx                 int64 // Index of the time bucket since the epoch, stale if not current.
+	total, errors, slow uint64
+}
+
+func (s *series) record(now time.Time, failed, slow bool) {
+	idx := now.UnixNano() / int64(s.resolution)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	b := &s.buckets[idx%int64(len(s.buckets))]
+	if b.idx != idx {
+		*b = bucket{idx: idx}
+	}
+	b.total++
+	if failed {
+		b.errors++
+	}
+	if slow {
+		b.slow++
+	}
+}
+
+// sum returns the total and bad requests for slo over the window ending now.
+func (s *series) sum(now time.Time, window time.Duration, slo string) (total, bad uint64) {
+	last := now.UnixNano() / int64(s.resolution)
+	first := last - int64(window/s.resolution) + 1
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, b := range s.buckets {
+		if b.idx < first || b.idx > last {
+			continue
+		}
+		total += b.total
+		if slo == Availability {
+			bad += b.errors
+		} else {
+			bad += b.slow
+		}
+	}
+	return total, bad
+}
@@ -0,0 +1,52 @@
+package extstress
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics holds metrics of the synthetic series generator.
+type Metrics struct {
+	series  prometheus.Gauge
+	created prometheus.Counter
+	deleted prometheus.Counter
+	bursts  prometheus.Counter
+}
+
+// NewMetrics creates a new instance of synthetic series generator Metrics.
+// It registers the metrics with the provided registerer.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	return &Metrics{
+		series: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "stress",
+				Name:      "series",
+				Help:      "Number of synthetic series currently exposed, counting every bucket, sum and count series of histograms.",
+			},
+		),
+
+		created: promauto.With(reg).NewCounter(
+			prometheus.CounterOpts{
+				Subsystem: "stress",
+				Name:      "series_created_total",
+				Help:      "Total number of synthetic series created.",
+			},
+		),
+
+		deleted: promauto.With(reg).NewCounter(
+			prometheus.CounterOpts{
+				Subsystem: "stress",
+				Name:      "series_deleted_total",
+				Help:      "Total number of synthetic series deleted by churn or the end of bursts.",
+			},
+		),
+
+		bursts: promauto.With(reg).NewCounter(
+			prometheus.CounterOpts{
+				Subsystem: "stress",
+				Name:      "bursts_total",
+				Help:      "Total number of bursts of new synthetic series.",
+			},
+		),
+	}
+}
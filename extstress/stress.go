@@ -0,0 +1,262 @@
+// Package extstress generates synthetic series with configurable churn on a Prometheus
+// registry, to load-test the cardinality handling of Prometheus compatible TSDBs. Series
+// differ by the value of their pod label, which is rotated over time to simulate pod churn,
+// and bursts of new series simulate e.g. rollouts or misbehaving exporters.
+package extstress
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Types of synthetic metrics.
+const (
+	TypeGauge     = "gauge"
+	TypeCounter   = "counter"
+	TypeHistogram = "histogram"
+)
+
+// PodLabel is the label synthetic series differ by.
+const PodLabel = "pod"
+
+// tickInterval is the interval synthetic series are updated, churned and burst in.
+const tickInterval = time.Second
+
+// Opts configures the synthetic series generator.
+type Opts struct {
+	// Metrics is the number of synthetic metric names.
+	Metrics int
+
+	// Type is the type of synthetic metrics, one of TypeGauge, TypeCounter or TypeHistogram.
+	// Histograms have a series per bucket plus sum and count series per pod.
+	Type string
+
+	// Pods is the number of pod label values, i.e. series per gauge or counter metric.
+	Pods int
+
+	// ExtraLabels is the number of extra labels with static values on every series, which
+	// increase the size but not the number of series.
+	ExtraLabels int
+
+	// ChurnRate is the number of pods replaced per second, deleting all their series and
+	// creating new ones.
+	ChurnRate float64
+
+	// BurstPods is the number of pods added during a burst.
+	BurstPods int
+
+	// BurstInterval is the interval bursts start in, the first one after an interval.
+	BurstInterval time.Duration
+
+	// BurstDuration is how long the pods of a burst exist.
+	BurstDuration time.Duration
+
+	// MaxSeries limits the total number of synthetic series. Pods beyond it fail New,
+	// bursts beyond it are clipped. Zero means no limit.
+	MaxSeries int
+}
+
+// DefaultOpts returns default synthetic series generator options.
+func DefaultOpts() Opts {
+	return Opts{
+		Metrics:       10,
+		Type:          TypeGauge,
+		Pods:          100,
+		BurstInterval: 10 * time.Minute,
+		BurstDuration: time.Minute,
+		MaxSeries:     100000,
+	}
+}
+
+// synthetic is a synthetic metric.
+type synthetic struct {
+	vec    *prometheus.MetricVec
+	update func(labelValues []string)
+}
+
+// Generator generates synthetic series.
+type Generator struct {
+	metrics      *Metrics
+	opts         Opts
+	synthetics   []synthetic
+	seriesPerPod int
+	extraValues  []string
+	start        time.Time
+
+	mtx       sync.Mutex
+	pods      []string // Steady pods, replaced round robin by churn.
+	nextChurn int      // Index of the next pod to replace.
+	churned   float64  // Pods due to be replaced, carrying fractions between ticks.
+	burst     []string // Pods of the active burst.
+	bursts    int      // Number of bursts started.
+	nextPodID uint64
+}
+
+// New creates a synthetic series generator, registering its synthetic metrics with reg.
+func New(reg prometheus.Registerer, metrics *Metrics, opts Opts) (*Generator, error) {
+	if opts.Metrics <= 0 {
+		return nil, errors.Errorf("number of metrics has to be positive, got %v", opts.Metrics)
+	}
+	if opts.Pods <= 0 {
+		return nil, errors.Errorf("number of pods has to be positive, got %v", opts.Pods)
+	}
+	if opts.ChurnRate < 0 || opts.BurstPods < 0 || opts.ExtraLabels < 0 {
+		return nil, errors.New("churn rate, burst pods and extra labels must not be negative")
+	}
+	if opts.BurstPods > 0 && (opts.BurstInterval <= 0 || opts.BurstDuration <= 0 || opts.BurstDuration >= opts.BurstInterval) {
+		return nil, errors.Errorf("burst duration %v has to be positive and shorter than the burst interval %v", opts.BurstDuration, opts.BurstInterval)
+	}
+
+	g := &Generator{metrics: metrics, opts: opts, seriesPerPod: 1, start: time.Now()}
+	if opts.Type == TypeHistogram {
+		// A series per bucket, the +Inf bucket, sum and count.
+		g.seriesPerPod = len(prometheus.DefBuckets) + 3
+	}
+	if steady := opts.Metrics * opts.Pods * g.seriesPerPod; opts.MaxSeries > 0 && steady > opts.MaxSeries {
+		return nil, errors.Errorf("%d metrics of type %s with %d pods make %d series, more than the maximum of %d", opts.Metrics, opts.Type, opts.Pods, steady, opts.MaxSeries)
+	}
+
+	labelNames := []string{PodLabel}
+	for i := range opts.ExtraLabels {
+		labelNames = append(labelNames, "label_"+strconv.Itoa(i))
+		g.extraValues = append(g.extraValues, fmt.Sprintf("synthetic-value-%d-%08x", i, rand.Uint32()))
+	}
+	for i := range opts.Metrics {
+		name := "stress_synthetic_" + strconv.Itoa(i)
+		help := "Synthetic metric generated to stress cardinality."
+		switch opts.Type {
+		case TypeGauge:
+			v := promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)
+			g.synthetics = append(g.synthetics, synthetic{vec: v.MetricVec, update: func(lvs []string) {
+				v.WithLabelValues(lvs...).Set(rand.Float64() * 100)
+			}})
+		case TypeCounter:
+			v := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{Name: name + "_total", Help: help}, labelNames)
+			g.synthetics = append(g.synthetics, synthetic{vec: v.MetricVec, update: func(lvs []string) {
+				v.WithLabelValues(lvs...).Add(rand.Float64() * 10)
+			}})
+		case TypeHistogram:
+			v := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{Name: name + "_seconds", Help: help, Buckets: prometheus.DefBuckets}, labelNames)
+			g.synthetics = append(g.synthetics, synthetic{vec: v.MetricVec, update: func(lvs []string) {
+				v.WithLabelValues(lvs...).Observe(rand.ExpFloat64() * 0.1)
+			}})
+		default:
+			return nil, errors.Errorf("unknown synthetic metric type %q, expected one of: %s, %s, %s", opts.Type, TypeGauge, TypeCounter, TypeHistogram)
+		}
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	for range opts.Pods {
+		g.pods = append(g.pods, g.createPodLocked("pod"))
+	}
+	g.metrics.series.Set(float64(g.seriesLocked()))
+	return g, nil
+}
+
+// Run updates, churns and bursts synthetic series until the context is done.
+func (g *Generator) Run(ctx context.Context) error {
+	g.mtx.Lock()
+	series := g.seriesLocked()
+	g.mtx.Unlock()
+	slog.Info("generating synthetic series", "metrics", g.opts.Metrics, "type", g.opts.Type, "pods", g.opts.Pods, "series", series, "churn_rate", g.opts.ChurnRate, "burst_pods", g.opts.BurstPods)
+
+	t := time.NewTicker(tickInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-t.C:
+			g.tick(now)
+		}
+	}
+}
+
+func (g *Generator) tick(now time.Time) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.churned += g.opts.ChurnRate * tickInterval.Seconds()
+	for ; g.churned >= 1; g.churned-- {
+		g.deletePodLocked(g.pods[g.nextChurn])
+		g.pods[g.nextChurn] = g.createPodLocked("pod")
+		g.nextChurn = (g.nextChurn + 1) % len(g.pods)
+	}
+
+	if g.opts.BurstPods > 0 {
+		elapsed := now.Sub(g.start)
+		active := elapsed >= g.opts.BurstInterval && elapsed%g.opts.BurstInterval < g.opts.BurstDuration
+		switch {
+		case active && g.burst == nil:
+			g.startBurstLocked()
+		case !active && g.burst != nil:
+			for _, pod := range g.burst {
+				g.deletePodLocked(pod)
+			}
+			slog.Info("synthetic series burst ended", "pods", len(g.burst))
+			g.burst = nil
+		}
+	}
+
+	for _, pods := range [][]string{g.pods, g.burst} {
+		for _, pod := range pods {
+			lvs := g.labelValues(pod)
+			for _, s := range g.synthetics {
+				s.update(lvs)
+			}
+		}
+	}
+	g.metrics.series.Set(float64(g.seriesLocked()))
+}
+
+func (g *Generator) startBurstLocked() {
+	pods := g.opts.BurstPods
+	if g.opts.MaxSeries > 0 {
+		pods = max(0, min(pods, (g.opts.MaxSeries-g.seriesLocked())/(g.opts.Metrics*g.seriesPerPod)))
+	}
+	g.bursts++
+	g.burst = []string{} // Non-nil while the burst is active, even if clipped to no pods.
+	for range pods {
+		g.burst = append(g.burst, g.createPodLocked("burst-"+strconv.Itoa(g.bursts)))
+	}
+	g.metrics.bursts.Inc()
+	slog.Info("synthetic series burst started", "pods", pods, "clipped", pods < g.opts.BurstPods)
+}
+
+// createPodLocked creates the series of a new pod and returns its label value.
+func (g *Generator) createPodLocked(prefix string) string {
+	g.nextPodID++
+	pod := fmt.Sprintf("%s-%06d", prefix, g.nextPodID)
+	lvs := g.labelValues(pod)
+	for _, s := range g.synthetics {
+		s.update(lvs)
+	}
+	g.metrics.created.Add(float64(len(g.synthetics) * g.seriesPerPod))
+	return pod
+}
+
+func (g *Generator) deletePodLocked(pod string) {
+	lvs := g.labelValues(pod)
+	for _, s := range g.synthetics {
+		s.vec.DeleteLabelValues(lvs...)
+	}
+	g.metrics.deleted.Add(float64(len(g.synthetics) * g.seriesPerPod))
+}
+
+func (g *Generator) labelValues(pod string) []string {
+	return append([]string{pod}, g.extraValues...)
+}
+
+func (g *Generator) seriesLocked() int {
+	return (len(g.pods) + len(g.burst)) * len(g.synthetics) * g.seriesPerPod
+}
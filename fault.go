@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/saswatamcode/pingpong/extrand"
+)
+
+// Fault modes that can be injected into pong responses.
+const (
+	// faultDelayHeader delays writing the response headers, then responds normally.
+	faultDelayHeader = "delay-header"
+	// faultStallBody writes part of the body, stalls, then writes the rest.
+	faultStallBody = "stall-body"
+	// faultClose writes part of the body, then closes the connection.
+	faultClose = "close"
+	// faultReset writes part of the body, then resets the connection.
+	faultReset = "reset"
+	// faultMalformed writes a response that is not valid HTTP.
+	faultMalformed = "malformed"
+	// faultHang never responds, until the client gives up.
+	faultHang = "hang"
+)
+
+var faultModes = []string{faultDelayHeader, faultStallBody, faultClose, faultReset, faultMalformed, faultHang}
+
+// malformedResponses are raw responses written by the malformed fault.
+var malformedResponses = []string{
+	"HTTP/1.1 2OO OK\r\nContent-Length: 5\r\n\r\npong\n",
+	"HTTP/1.1 200 OK\r\nContent-Length: five\r\n\r\npong\n",
+	"HTTP/1.1 200 OK\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\npong\n",
+	"pong\n",
+}
+
+// faultInjector injects faults into pong responses with configured probabilities.
+type faultInjector struct {
+	faults     *extrand.WeightedChoice[string]
+	delay      time.Duration
+	afterBytes int64
+
+	injected *prometheus.CounterVec
+}
+
+// newFaultInjector creates a faultInjector from faults encoded as
+// <probability>%<fault>,<probability>%<fault>... Probabilities may add up to less than 100,
+// the remainder of requests is not faulted. It returns nil if no faults are configured.
+func newFaultInjector(reg prometheus.Registerer, encodedFaults string, delay time.Duration, afterBytes string) (*faultInjector, error) {
+	if encodedFaults == "" {
+		return nil, nil
+	}
+
+	faults, err := extrand.NewPartialWeightedChoice(encodedFaults, func(s string) (string, error) {
+		for _, m := range faultModes {
+			if s == m {
+				return s, nil
+			}
+		}
+		return "", errors.Errorf("unknown fault %q, expected one of: %v", s, faultModes)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing faults")
+	}
+
+	n, err := parseSize(afterBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing fault after bytes")
+	}
+
+	f := &faultInjector{
+		faults:     faults,
+		delay:      delay,
+		afterBytes: n,
+		injected: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "pong",
+			Name:      "faults_injected_total",
+			Help:      "Total number of faults injected into pong responses.",
+		}, []string{"fault"}),
+	}
+	for _, m := range faultModes {
+		f.injected.WithLabelValues(m)
+	}
+	slog.Info("fault injector created", "faults", faults.Values(), "delay", delay, "after_bytes", n)
+	return f, nil
+}
+
+// Inject rolls for a fault and injects it. It returns true if the response was
+// handled by the fault and the caller must not write anything else.
+func (f *faultInjector) Inject(w http.ResponseWriter, r *http.Request) bool {
+	fault, ok := f.faults.Pick()
+	if !ok {
+		return false
+	}
+	f.injected.WithLabelValues(fault).Inc()
+	slog.Debug("injecting fault", "fault", fault, "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+
+	switch fault {
+	case faultDelayHeader:
+		f.wait(r)
+		return false
+	case faultHang:
+		<-r.Context().Done()
+	case faultStallBody:
+		f.writePartial(w)
+		f.wait(r)
+		_ = writeFiller(w, f.afterBytes)
+	case faultClose, faultReset:
+		f.writePartial(w)
+		abortConn(w, fault == faultReset)
+	case faultMalformed:
+		conn, ok := hijack(w)
+		if !ok {
+			panic(http.ErrAbortHandler)
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte(malformedResponses[rand.Intn(len(malformedResponses))]))
+	}
+	return true
+}
+
+// wait blocks for the fault delay or until the request is cancelled.
+func (f *faultInjector) wait(r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(f.delay):
+	}
+}
+
+// writePartial writes headers announcing twice afterBytes, but only afterBytes of the body.
+func (f *faultInjector) writePartial(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.FormatInt(2*f.afterBytes, 10))
+	w.WriteHeader(http.StatusOK)
+	_ = writeFiller(w, f.afterBytes)
+	_ = http.NewResponseController(w).Flush()
+}
+
+// abortConn closes the underlying connection. If reset is true, the TCP connection is
+// reset instead of closed gracefully. Connections that can't be hijacked, e.g. HTTP/2
+// streams, are aborted by the server instead.
+func abortConn(w http.ResponseWriter, reset bool) {
+	conn, ok := hijack(w)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	netConn := conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		netConn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := netConn.(*net.TCPConn); ok && reset {
+		_ = tcpConn.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+func hijack(w http.ResponseWriter) (net.Conn, bool) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		slog.Debug("failed to hijack connection", "error", err)
+		return nil, false
+	}
+	return conn, true
+}
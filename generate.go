@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/saswatamcode/pingpong/extdb"
+	"github.com/saswatamcode/pingpong/exthttp"
+	"github.com/saswatamcode/pingpong/extpush"
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v2"
+)
+
+var (
+	// generate command flags
+	generateOutput  string
+	generateHandler string
+)
+
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate Prometheus rules and Grafana dashboards",
+	Long:  "Generate Prometheus recording rules, multi-window burn-rate SLO alerts and Grafana dashboards for the metrics exposed by ping and pong.",
+}
+
+var generateRulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Generate Prometheus recording and alerting rules",
+	Long:  "Generate a Prometheus rule file with recording rules for ping, pong and DB metrics, and multi-window burn-rate alerts for the availability and latency SLOs of pong.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateSLOs(); err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(generateRules())
+		if err != nil {
+			return errors.Wrap(err, "marshaling rules")
+		}
+		return writeGenerated(b)
+	},
+}
+
+var generateDashboardCmd = &cobra.Command{
+	Use:   "dashboard",
+	Short: "Generate a Grafana dashboard",
+	Long:  "Generate Grafana dashboard JSON with ping, pong and DB panels.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := validateSLOs(); err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(generateDashboard(), "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshaling dashboard")
+		}
+		return writeGenerated(append(b, '\n'))
+	},
+}
+
+func init() {
+	// generate command flags
+	generateCmd.PersistentFlags().StringVarP(&generateOutput, "output", "o", "-", "File to write the generated output to, - for stdout.")
+	generateCmd.PersistentFlags().StringVar(&generateHandler, "handler", "/ping", "Handler of pong the SLOs apply to.")
+
+	generateCmd.AddCommand(generateRulesCmd)
+	generateCmd.AddCommand(generateDashboardCmd)
+	rootCmd.AddCommand(generateCmd)
+}
+
+func validateSLOs() error {
+	for _, t := range []float64{sloAvailability, sloLatencyTarget} {
+		if t <= 0 || t >= 100 {
+			return errors.Errorf("SLO targets have to be between 0 and 100 exclusive, got %v", t)
+		}
+	}
+	// Classic histograms only count requests faster than the latency at bucket boundaries.
+	if !slices.Contains(httpDurationBuckets, sloLatency.Seconds()) {
+		return errors.Errorf("SLO latency %v has to be a bucket boundary of %v, one of %v", sloLatency, exthttp.MetricRequestDuration, httpDurationBuckets)
+	}
+	return nil
+}
+
+func writeGenerated(b []byte) error {
+	var w io.Writer = os.Stdout
+	if generateOutput != "-" {
+		f, err := os.Create(generateOutput)
+		if err != nil {
+			return errors.Wrap(err, "creating output file")
+		}
+		defer f.Close()
+		w = f
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// ruleFile is a Prometheus rule file.
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// burnRateAlert is a multi-window burn-rate alert, firing if the error budget burns faster
+// than factor over both the long and the short window, see https://sre.google/workbook/alerting-on-slos/.
+type burnRateAlert struct {
+	long, short string
+	factor      float64
+	severity    string
+}
+
+var burnRateAlerts = []burnRateAlert{
+	{long: "1h", short: "5m", factor: 14.4, severity: "critical"},
+	{long: "6h", short: "30m", factor: 6, severity: "critical"},
+	{long: "1d", short: "2h", factor: 3, severity: "warning"},
+	{long: "3d", short: "6h", factor: 1, severity: "warning"},
+}
+
+// burnRateWindows are the windows burn-rate alerts need error ratios for.
+var burnRateWindows = []string{"5m", "30m", "1h", "2h", "6h", "1d", "3d"}
+
+// quantiles recorded for latency histograms.
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+func generateRules() ruleFile {
+	handler := fmt.Sprintf(`handler=%q`, generateHandler)
+	slow := sloLatency.Seconds()
+
+	var slo []rule
+	for _, w := range burnRateWindows {
+		slo = append(slo,
+			rule{
+				Record: "job_handler:slo_errors:ratio_rate" + w,
+				Expr: fmt.Sprintf(`sum by (job, handler) (rate(%s{%s, code=~"5.."}[%s])) / sum by (job, handler) (rate(%s{%s}[%s]))`,
+					exthttp.MetricRequestsTotal, handler, w, exthttp.MetricRequestsTotal, handler, w),
+			},
+			rule{
+				Record: "job_handler:slo_slow_requests:ratio_rate" + w,
+				Expr: fmt.Sprintf(`(1 - histogram_fraction(0, %v, sum by (job, handler) (rate(%s{%s}[%s])))) or `+
+					`(1 - sum by (job, handler) (rate(%s_bucket{%s, le=%q}[%s])) / sum by (job, handler) (rate(%s_count{%s}[%s])))`,
+					slow, exthttp.MetricRequestDuration, handler, w,
+					exthttp.MetricRequestDuration, handler, extpush.FormatFloat(slow), w, exthttp.MetricRequestDuration, handler, w),
+			},
+		)
+	}
+
+	var alerts []rule
+	for _, s := range []struct {
+		name, record, description string
+		target                    float64
+	}{
+		{"PongErrorBudgetBurn", "slo_errors", fmt.Sprintf("Pong %s is failing too many requests for its %v%% availability SLO.", generateHandler, sloAvailability), sloAvailability},
+		{"PongLatencyBudgetBurn", "slo_slow_requests", fmt.Sprintf("Pong %s is serving too many requests slower than %v for its %v%% latency SLO.", generateHandler, sloLatency, sloLatencyTarget), sloLatencyTarget},
+	} {
+		budget := 1 - s.target/100
+		for _, a := range burnRateAlerts {
+			threshold := strconv.FormatFloat(a.factor*budget, 'g', 6, 64)
+			alerts = append(alerts, rule{
+				Alert: s.name,
+				Expr: fmt.Sprintf("job_handler:%s:ratio_rate%s > %s and job_handler:%s:ratio_rate%s > %s",
+					s.record, a.long, threshold, s.record, a.short, threshold),
+				Labels: map[string]string{"severity": a.severity, "long_window": a.long},
+				Annotations: map[string]string{
+					"summary":     fmt.Sprintf("Error budget is burning %vx too fast over %s.", a.factor, a.long),
+					"description": s.description,
+				},
+			})
+		}
+	}
+	alerts = append(alerts, rule{
+		Alert:  "PingpongDBDown",
+		Expr:   fmt.Sprintf(`%s{state="down"} == 1`, extdb.MetricState),
+		For:    "1m",
+		Labels: map[string]string{"severity": "critical"},
+		Annotations: map[string]string{
+			"summary": "The simulated database is down.",
+		},
+	})
+
+	return ruleFile{Groups: []ruleGroup{
+		{Name: "pingpong.rules", Rules: recordingRules()},
+		{Name: "pingpong.slo.rules", Rules: slo},
+		{Name: "pingpong.slo.alerts", Rules: alerts},
+	}}
+}
+
+func recordingRules() []rule {
+	rules := []rule{
+		{
+			Record: "job_handler_code:http_requests:rate5m",
+			Expr:   fmt.Sprintf("sum by (job, handler, code) (rate(%s[5m]))", exthttp.MetricRequestsTotal),
+		},
+		{
+			Record: "job_code:http_client_requests:rate5m",
+			Expr:   fmt.Sprintf("sum by (job, code) (rate(%s[5m]))", exthttp.MetricClientRequestsTotal),
+		},
+		{
+			Record: "job_operation_table_status:db_queries:rate5m",
+			Expr:   fmt.Sprintf("sum by (job, operation, table, status) (rate(%s[5m]))", extdb.MetricQueriesTotal),
+		},
+		{
+			Record: "job_operation_table_error_type:db_query_errors:rate5m",
+			Expr:   fmt.Sprintf("sum by (job, operation, table, error_type) (rate(%s[5m]))", extdb.MetricQueryErrorsTotal),
+		},
+	}
+	for _, h := range []struct {
+		level  string
+		by     []string
+		metric string
+	}{
+		{"job_handler", []string{"job", "handler"}, exthttp.MetricRequestDuration},
+		{"job", []string{"job"}, exthttp.MetricClientRequestDuration},
+		{"job_operation_table", []string{"job", "operation", "table"}, extdb.MetricQueryDuration},
+	} {
+		for _, q := range quantiles {
+			rules = append(rules, rule{
+				Record: fmt.Sprintf("%s:%s:p%s_rate5m", h.level, h.metric, quantileName(q)),
+				Expr:   histogramQuantile(q, h.by, h.metric, "", "5m"),
+			})
+		}
+	}
+	return rules
+}
+
+// histogramQuantile returns a query of the q-quantile of a histogram aggregated by the given
+// labels. It prefers the native histogram and falls back to the classic buckets, which are all
+// there is if Prometheus does not scrape native histograms or metrics are pushed with remote-write.
+func histogramQuantile(q float64, by []string, metric, selector, window string) string {
+	query := func(metric string, by []string) string {
+		if selector != "" {
+			metric += "{" + selector + "}"
+		}
+		agg := "sum"
+		if len(by) > 0 {
+			agg = fmt.Sprintf("sum by (%s) ", strings.Join(by, ", "))
+		}
+		return fmt.Sprintf("histogram_quantile(%v, %s(rate(%s[%s])))", q, agg, metric, window)
+	}
+	return query(metric, by) + " or " + query(metric+"_bucket", slices.Concat(by, []string{"le"}))
+}
+
+// quantileName formats a quantile for rule names, e.g. 0.99 as 99.
+func quantileName(q float64) string {
+	return strings.TrimPrefix(strconv.FormatFloat(q*100, 'f', -1, 64), "0.")
+}
+
+// dashboard is a minimal Grafana dashboard model.
+type dashboard struct {
+	Title         string         `json:"title"`
+	UID           string         `json:"uid"`
+	Tags          []string       `json:"tags"`
+	Timezone      string         `json:"timezone"`
+	SchemaVersion int            `json:"schemaVersion"`
+	Refresh       string         `json:"refresh"`
+	Time          map[string]any `json:"time"`
+	Templating    map[string]any `json:"templating"`
+	Panels        []panel        `json:"panels"`
+}
+
+type panel struct {
+	ID          int            `json:"id"`
+	Type        string         `json:"type"`
+	Title       string         `json:"title"`
+	GridPos     gridPos        `json:"gridPos"`
+	Datasource  map[string]any `json:"datasource,omitempty"`
+	Targets     []target       `json:"targets,omitempty"`
+	FieldConfig map[string]any `json:"fieldConfig,omitempty"`
+	Collapsed   bool           `json:"collapsed,omitempty"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type target struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+// dashboardBuilder lays out panels in rows of two.
+type dashboardBuilder struct {
+	panels []panel
+	x, y   int
+}
+
+func (b *dashboardBuilder) row(title string) {
+	if b.x != 0 {
+		b.x, b.y = 0, b.y+8
+	}
+	b.panels = append(b.panels, panel{
+		ID: len(b.panels) + 1, Type: "row", Title: title,
+		GridPos: gridPos{H: 1, W: 24, X: 0, Y: b.y},
+	})
+	b.y++
+}
+
+// timeseries adds a time series panel. Exprs alternate between queries and their legend format.
+func (b *dashboardBuilder) timeseries(title, unit string, exprs ...string) {
+	p := panel{
+		ID:          len(b.panels) + 1,
+		Type:        "timeseries",
+		Title:       title,
+		GridPos:     gridPos{H: 8, W: 12, X: b.x, Y: b.y},
+		Datasource:  map[string]any{"type": "prometheus", "uid": "${datasource}"},
+		FieldConfig: map[string]any{"defaults": map[string]any{"unit": unit}, "overrides": []any{}},
+	}
+	for i := 0; i+1 < len(exprs); i += 2 {
+		p.Targets = append(p.Targets, target{Expr: exprs[i], LegendFormat: exprs[i+1], RefID: string(rune('A' + i/2))})
+	}
+	b.panels = append(b.panels, p)
+
+	if b.x == 0 {
+		b.x = 12
+		return
+	}
+	b.x, b.y = 0, b.y+8
+}
+
+func generateDashboard() dashboard {
+	b := &dashboardBuilder{}
+	job := `job=~"$job"`
+
+	b.row("Ping")
+	b.timeseries("Requests", "reqps",
+		fmt.Sprintf("sum by (code) (rate(%s{%s}[$__rate_interval]))", exthttp.MetricClientRequestsTotal, job), "{{code}}")
+	b.timeseries("Request latency", "s", clientQuantiles(exthttp.MetricClientRequestDuration, job)...)
+	b.timeseries("Time to first byte", "s", clientQuantiles(exthttp.MetricClientTimeToFirstByte, job)...)
+	b.timeseries("Connections", "cps",
+		fmt.Sprintf("sum by (reused) (rate(%s{%s}[$__rate_interval]))", exthttp.MetricClientConnsTotal, job), "reused={{reused}}",
+		fmt.Sprintf("sum by (result) (rate(%s_count{%s}[$__rate_interval]))", exthttp.MetricClientConnectDuration, job), "dial {{result}}")
+
+	b.row("Pong")
+	b.timeseries("Requests", "reqps",
+		fmt.Sprintf(`sum by (handler, code) (rate(%s{%s, handler!="/metrics"}[$__rate_interval]))`, exthttp.MetricRequestsTotal, job), "{{handler}} {{code}}")
+	b.timeseries("Request latency", "s", serverQuantiles(job)...)
+	b.timeseries("Availability SLO error ratio", "percentunit",
+		fmt.Sprintf(`job_handler:slo_errors:ratio_rate5m{%s}`, job), "5m",
+		fmt.Sprintf(`job_handler:slo_errors:ratio_rate1h{%s}`, job), "1h",
+		strconv.FormatFloat(1-sloAvailability/100, 'g', 6, 64), "budget")
+	b.timeseries("In-flight requests", "short",
+		fmt.Sprintf(`sum by (handler) (%s{%s})`, exthttp.MetricInflightRequests, job), "{{handler}}")
+
+	b.row("DB")
+	b.timeseries("Queries", "qps",
+		fmt.Sprintf("sum by (operation, table, status) (rate(%s{%s}[$__rate_interval]))", extdb.MetricQueriesTotal, job), "{{operation}} {{table}} {{status}}")
+	b.timeseries("Query latency p99", "s",
+		histogramQuantile(0.99, []string{"operation", "table"}, extdb.MetricQueryDuration, job, "$__rate_interval"), "{{operation}} {{table}}")
+	b.timeseries("Query errors", "qps",
+		fmt.Sprintf("sum by (error_type) (rate(%s{%s}[$__rate_interval]))", extdb.MetricQueryErrorsTotal, job), "{{error_type}}")
+	b.timeseries("Connection pool", "short",
+		fmt.Sprintf("sum by (state) (%s{%s})", extdb.MetricConnectionPool, job), "{{state}}")
+	b.timeseries("Transactions", "ops",
+		fmt.Sprintf("sum by (status) (rate(%s{%s}[$__rate_interval]))", extdb.MetricTransactionsTotal, job), "{{status}}")
+	b.timeseries("State", "short",
+		fmt.Sprintf("max by (state) (%s{%s})", extdb.MetricState, job), "{{state}}")
+
+	return dashboard{
+		Title:         "Pingpong",
+		UID:           "pingpong",
+		Tags:          []string{"pingpong"},
+		Timezone:      "browser",
+		SchemaVersion: 39,
+		Refresh:       "30s",
+		Time:          map[string]any{"from": "now-1h", "to": "now"},
+		Templating: map[string]any{"list": []any{
+			map[string]any{
+				"name":  "datasource",
+				"type":  "datasource",
+				"query": "prometheus",
+			},
+			map[string]any{
+				"name":       "job",
+				"type":       "query",
+				"datasource": map[string]any{"type": "prometheus", "uid": "${datasource}"},
+				"query":      fmt.Sprintf("label_values(%s, job)", exthttp.MetricRequestsTotal),
+				"includeAll": true,
+				"multi":      true,
+				"refresh":    2,
+			},
+		}},
+		Panels: b.panels,
+	}
+}
+
+func clientQuantiles(metric, selector string) []string {
+	var exprs []string
+	for _, q := range quantiles {
+		exprs = append(exprs,
+			histogramQuantile(q, nil, metric, selector, "$__rate_interval"),
+			"p"+quantileName(q))
+	}
+	return exprs
+}
+
+func serverQuantiles(selector string) []string {
+	var exprs []string
+	for _, q := range quantiles {
+		exprs = append(exprs,
+			histogramQuantile(q, []string{"handler"}, exthttp.MetricRequestDuration, selector+`, handler!="/metrics"`, "$__rate_interval"),
+			"{{handler}} p"+quantileName(q))
+	}
+	return exprs
+}
@@ -8,10 +8,10 @@ import (
 	"io"
 	"log/slog"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -26,37 +26,151 @@ import (
 	"github.com/prometheus/common/promslog"
 	psflag "github.com/prometheus/common/promslog/flag"
 	"github.com/prometheus/common/version"
+	"github.com/saswatamcode/pingpong/extcache"
 	"github.com/saswatamcode/pingpong/extdb"
+	"github.com/saswatamcode/pingpong/extgate"
 	"github.com/saswatamcode/pingpong/exthttp"
+	"github.com/saswatamcode/pingpong/extlog"
+	"github.com/saswatamcode/pingpong/extprobe"
+	"github.com/saswatamcode/pingpong/extpush"
+	"github.com/saswatamcode/pingpong/extqueue"
+	"github.com/saswatamcode/pingpong/extrand"
+	"github.com/saswatamcode/pingpong/extslo"
+	"github.com/saswatamcode/pingpong/extstress"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
-	latDecider  *latencyDecider
+	latencies   *extrand.WeightedChoice[time.Duration]
+	payload     *payloadWriter
+	faults      *faultInjector
 	dbSimulator *extdb.Simulator
+	cache       *extcache.Cache
+	queue       *extqueue.Queue
+	logs        *extlog.Logger
+	dbPolicy    *dbErrorPolicy
+	dbPlan      []dbQuery
+	pingHeaders []requestHeader
 
 	// root command flags
 	logLevelStr  string
 	logFormatStr string
 
+	// server TLS flags, shared by ping and pong
+	serverTLS exthttp.TLSServerOpts
+
+	// metrics push flags, shared by ping and pong
+	pushInterval       time.Duration
+	pushTimeout        time.Duration
+	pushExternalLabels map[string]string
+	pushHeaders        map[string]string
+	pushRetry          extpush.RetryOpts
+	remoteWriteURL     string
+	otlpURL            string
+	otlpProtocol       string
+
+	// probe flags, shared by ping and pong
+	probeOpts extprobe.Opts
+
+	// shutdown flags, shared by ping and pong
+	shutdownDelay        time.Duration
+	shutdownDrainTimeout time.Duration
+
+	// debug flags, shared by ping and pong
+	debugEnabled       bool
+	debugListenAddress string
+
+	// access log flags, shared by ping and pong
+	accessLog            bool
+	accessLogSampleRates string
+	accessLogOpts        exthttp.AccessLogOpts
+
+	// cardinality stress flags, shared by ping and pong
+	stressEnabled bool
+	stressOpts    extstress.Opts
+
+	// SLO flags, shared by pong and generate
+	sloAvailability  float64
+	sloLatency       time.Duration
+	sloLatencyTarget float64
+
 	// pong command flags
 	pongAddr    string
 	appVersion  string
 	lat         string
 	successProb float64
+	http2       bool
+	h2c         bool
+	capacity    extgate.Opts
+
+	// SLO tracking flags
+	sloWindows      []time.Duration
+	sloBudgetWindow time.Duration
+
+	// metrics label flags
+	extraLabels          []string
+	extraLabelsMaxValues int
+
+	// response payload flags
+	responseSizes        string
+	responseContentTypes string
+	responseChunkSize    string
+	responseChunkDelay   time.Duration
+
+	// fault injection flags
+	faultsEncoded   string
+	faultDelay      time.Duration
+	faultAfterBytes string
+
+	// cache simulation flags
+	cacheEnabled bool
+	cacheOpts    extcache.Opts
+
+	// queue simulation flags
+	queueEnabled bool
+	queueOpts    extqueue.Opts
+
+	// synthetic log flags
+	logsOutput      string
+	logsOpts        extlog.Opts
+	logsMaxFileSize string
 
 	// database simulation flags
 	dbEnabled     bool
 	dbLatency     string
 	dbSuccessProb float64
 	dbErrorTypes  string
+	dbConfigFile  string
+	dbPool        extdb.PoolOpts
+	dbCapacity    extgate.Opts
+	dbTx          bool
+	dbTxConflict  float64
+	dbState       extdb.StateOpts
+	dbFailPolicy  string
+	dbRetries     int
+	dbRetryWait   time.Duration
 
 	// ping command flags
 	pingAddr    string
 	endpoint    string
 	pingsPerSec int
+	httpProto   string
+	headers     []string
+
+	// ping connection flags
+	keepAlive           time.Duration
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	newConnPerRequest   bool
+
+	// ping client TLS flags
+	endpointTLS exthttp.TLSClientOpts
 )
 
+// httpDurationBuckets are the buckets of http_request_duration_seconds of ping and pong.
+var httpDurationBuckets = []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120, 240, 360, 720}
+
 var rootCmd = &cobra.Command{
 	Use:   "pingpong",
 	Short: "Pingpong is a demo HTTP client/server for testing",
@@ -72,12 +186,13 @@ var rootCmd = &cobra.Command{
 			panic(fmt.Sprintf("invalid log format: %v", err))
 		}
 
-		slog.SetDefault(promslog.New(&promslog.Config{
+		logger := promslog.New(&promslog.Config{
 			Level:  logLevel,
 			Format: logFormat,
 			Style:  promslog.GoKitStyle,
 			Writer: os.Stderr,
-		}))
+		})
+		slog.SetDefault(slog.New(extlog.NewTraceHandler(logger.Handler())))
 
 		return nil
 	},
@@ -88,7 +203,7 @@ var pongCmd = &cobra.Command{
 	Short: "Start the pong HTTP server",
 	Long:  "Start the pong HTTP server that responds to /ping requests with configurable latency and success probability.",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runPongServer()
+		return runPongServer(cmd)
 	},
 }
 
@@ -97,7 +212,7 @@ var pingCmd = &cobra.Command{
 	Short: "Start the ping client that sends requests to a pong server",
 	Long:  "Start the ping client that continuously sends HTTP requests to a pong server endpoint with configurable rate.",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return runPinger()
+		return runPinger(cmd)
 	},
 }
 
@@ -111,107 +226,255 @@ func init() {
 	pongCmd.Flags().StringVar(&appVersion, "set-version", "first", "Injected version to be presented via metrics.")
 	pongCmd.Flags().StringVar(&lat, "latency", "90%500ms,10%200ms", "Encoded latency and probability of the response in format as: <probability>%<duration>,<probability>%<duration>....")
 	pongCmd.Flags().Float64Var(&successProb, "success-prob", 100, "The probability (in %) of getting a successful response")
+	pongCmd.Flags().BoolVar(&http2, "http2", true, "Serve HTTP/2 over TLS (h2) when TLS is enabled.")
+	pongCmd.Flags().BoolVar(&h2c, "h2c", false, "Serve cleartext HTTP/2 with prior knowledge (h2c) alongside HTTP/1.1.")
+	pongCmd.Flags().IntVar(&capacity.Workers, "capacity-workers", 0, "Number of /ping requests served concurrently, further requests queue. 0 disables the capacity model.")
+	pongCmd.Flags().IntVar(&capacity.QueueSize, "capacity-queue-size", 100, "Number of /ping requests that may queue for a worker before being rejected with 503.")
+	pongCmd.Flags().DurationVar(&capacity.QueueTimeout, "capacity-queue-timeout", 0, "How long a /ping request may queue for a worker before being rejected with 503. 0 waits until the request is cancelled.")
+
+	// SLO tracking flags
+	pongCmd.Flags().DurationSliceVar(&sloWindows, "slo-windows", []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour}, "Sliding windows pong computes SLIs and burn rates of its SLOs over, exposed as slo_sli and slo_burn_rate and on /slo.")
+	pongCmd.Flags().DurationVar(&sloBudgetWindow, "slo-budget-window", time.Hour, "Sliding window pong computes the remaining error budget of its SLOs over, exposed as slo_error_budget_remaining and on /slo.")
+
+	// metrics label flags
+	pongCmd.Flags().StringArrayVar(&extraLabels, "metrics-extra-label", nil, "Extra label of the pong HTTP server metrics in format: <name>=<source>:<key>[:<value>|<value>...] with source one of [header, path, query], e.g. tenant=header:X-Tenant:acme|globex. Values not listed are recorded as \"other\". Can be repeated.")
+	pongCmd.Flags().IntVar(&extraLabelsMaxValues, "metrics-extra-label-max-values", 10, "Maximum number of distinct values of extra labels without listed values, further values are recorded as \"other\". 0 means no limit.")
+
+	// response payload flags
+	pongCmd.Flags().StringVar(&responseSizes, "response-size", "", "Encoded size and probability of successful response bodies in format: <probability>%<size>,<probability>%<size>... e.g. 80%1KiB,15%100KiB,5%5MiB. Defaults to the plain \"pong\" body.")
+	pongCmd.Flags().StringVar(&responseContentTypes, "response-content-types", "", "Encoded content type and probability of successful responses in format: <probability>%<content_type>,... Defaults to text/plain.")
+	pongCmd.Flags().StringVar(&responseChunkSize, "response-chunk-size", "", "Stream response bodies in chunks of this size, flushing after each chunk. Empty writes the body at once.")
+	pongCmd.Flags().DurationVar(&responseChunkDelay, "response-chunk-delay", 0, "Delay between response body chunks to simulate a slow body. Requires --response-chunk-size.")
+
+	// fault injection flags
+	pongCmd.Flags().StringVar(&faultsEncoded, "faults", "", "Encoded faults and their probability in format: <probability>%<fault>,... Probabilities may add up to less than 100, the remainder is not faulted. Faults: delay-header, stall-body, close, reset, malformed, hang.")
+	pongCmd.Flags().DurationVar(&faultDelay, "fault-delay", 10*time.Second, "How long delay-header and stall-body faults wait.")
+	pongCmd.Flags().StringVar(&faultAfterBytes, "fault-after-bytes", "1KiB", "How much of the body stall-body, close and reset faults write before stalling or dropping the connection.")
+
+	// cache simulation flags
+	cacheDefaults := extcache.DefaultOpts()
+	pongCmd.Flags().BoolVar(&cacheEnabled, "cache-enabled", false, "Simulate a cache in front of the simulated DB, which is only queried on cache misses. Requires --db-enabled.")
+	pongCmd.Flags().StringVar(&cacheOpts.Latency, "cache-latency", cacheDefaults.Latency, "Encoded latency and probability for simulated cache operations in format: <probability>%<duration>,<probability>%<duration>....")
+	pongCmd.Flags().Float64Var(&cacheOpts.HitRatio, "cache-hit-ratio", cacheDefaults.HitRatio, "The probability (in %) of a simulated cache hit. Ignored if --cache-keyspace is set.")
+	pongCmd.Flags().IntVar(&cacheOpts.Keyspace, "cache-keyspace", 0, "Number of distinct keys looked up in the simulated cache. If set, hits depend on an LRU of --cache-capacity keys instead of --cache-hit-ratio, starting cold.")
+	pongCmd.Flags().IntVar(&cacheOpts.Capacity, "cache-capacity", cacheDefaults.Capacity, "Maximum number of keys in the simulated cache, least recently used keys are evicted.")
+	pongCmd.Flags().Float64Var(&cacheOpts.KeySkew, "cache-key-skew", cacheDefaults.KeySkew, "Skew of the Zipf distribution simulated cache keys are chosen with, has to be larger than 1. 0 chooses keys uniformly.")
+	pongCmd.Flags().DurationVar(&cacheOpts.TTL, "cache-ttl", 0, "How long simulated cache keys are cached. 0 means until evicted.")
+	pongCmd.Flags().BoolVar(&cacheOpts.Coalesce, "cache-coalesce", cacheDefaults.Coalesce, "Coalesce concurrent simulated cache misses of the same key into a single DB query. If false, misses cause a stampede of DB queries.")
+
+	// queue simulation flags
+	queueDefaults := extqueue.DefaultOpts()
+	pongCmd.Flags().BoolVar(&queueEnabled, "queue-enabled", false, "Publish a message to a simulated in-process queue on every ping request, processed asynchronously by simulated consumers.")
+	pongCmd.Flags().IntVar(&queueOpts.Capacity, "queue-capacity", queueDefaults.Capacity, "Maximum number of messages waiting in the simulated queue, further messages are rejected.")
+	pongCmd.Flags().IntVar(&queueOpts.Consumers, "queue-consumers", queueDefaults.Consumers, "Number of messages processed concurrently by simulated queue consumers.")
+	pongCmd.Flags().StringVar(&queueOpts.ProcessingLatency, "queue-processing-latency", queueDefaults.ProcessingLatency, "Encoded latency and probability for processing simulated queue messages in format: <probability>%<duration>,<probability>%<duration>....")
+	pongCmd.Flags().Float64Var(&queueOpts.SuccessProb, "queue-success-prob", queueDefaults.SuccessProb, "The probability (in %) of a simulated queue message being processed successfully.")
+	pongCmd.Flags().IntVar(&queueOpts.MaxDeliveries, "queue-max-deliveries", queueDefaults.MaxDeliveries, "How often a failing simulated queue message is delivered before it is moved to the dead-letter queue.")
+	pongCmd.Flags().DurationVar(&queueOpts.RedeliveryDelay, "queue-redelivery-delay", queueDefaults.RedeliveryDelay, "How long a failed simulated queue message waits before it is redelivered.")
+
+	// synthetic log flags
+	logsDefaults := extlog.DefaultOpts()
+	pongCmd.Flags().StringVar(&logsOutput, "synthetic-logs-output", "", "Write a synthetic log workload of access and application logs to this output. One of: [stderr, file, otlp]. Disabled if empty.")
+	pongCmd.Flags().StringVar(&logsOpts.File, "synthetic-logs-file", "pong.log", "Path of the synthetic log file for the file output.")
+	pongCmd.Flags().StringVar(&logsMaxFileSize, "synthetic-logs-file-max-size", "100MiB", "Size the synthetic log file is rotated at. 0 disables rotation.")
+	pongCmd.Flags().IntVar(&logsOpts.MaxFiles, "synthetic-logs-file-max-files", logsDefaults.MaxFiles, "Number of rotated synthetic log files kept.")
+	pongCmd.Flags().StringVar(&logsOpts.OTLPURL, "synthetic-logs-otlp-url", logsDefaults.OTLPURL, "OTLP/HTTP endpoint for the otlp output. An http scheme disables TLS.")
+	pongCmd.Flags().StringVar(&logsOpts.Format, "synthetic-logs-format", logsDefaults.Format, "Format of synthetic application logs. One of: [logfmt, json]. Stack traces follow logfmt records as separate lines.")
+	pongCmd.Flags().StringVar(&logsOpts.AccessFormat, "synthetic-logs-access-format", logsDefaults.AccessFormat, "Format of access logs of every request. One of: [common, combined, json]. Disabled if empty.")
+	pongCmd.Flags().Float64Var(&logsOpts.AppRate, "synthetic-logs-rate", logsDefaults.AppRate, "Number of synthetic application logs per second.")
+	pongCmd.Flags().StringVar(&logsOpts.AppLevels, "synthetic-logs-levels", logsDefaults.AppLevels, "Encoded level mix of synthetic application logs in format: <probability>%<level>,<probability>%<level>.... Error logs include a stack trace.")
 
 	// database simulation flags
 	pongCmd.Flags().BoolVar(&dbEnabled, "db-enabled", false, "Enable database simulation metrics")
 	pongCmd.Flags().StringVar(&dbLatency, "db-latency", "90%10ms,10%50ms", "Encoded latency and probability for simulated DB queries in format: <probability>%<duration>,<probability>%<duration>....")
 	pongCmd.Flags().Float64Var(&dbSuccessProb, "db-success-prob", 95, "The probability (in %) of a successful simulated DB query")
 	pongCmd.Flags().StringVar(&dbErrorTypes, "db-error-types", "50%timeout,30%connection,20%deadlock", "Distribution of error types when DB queries fail in format: <probability>%<error_type>,...")
+	pongCmd.Flags().StringVar(&dbConfigFile, "db-config-file", "", "YAML file with simulated DB profiles per table and operation overriding the flags above, and the query plan executed by every request. See the README for the format.")
+	pongCmd.Flags().IntVar(&dbPool.MaxOpen, "db-max-open-conns", 0, "Maximum open connections of the simulated DB connection pool. 0 disables pool simulation.")
+	pongCmd.Flags().IntVar(&dbPool.MaxIdle, "db-max-idle-conns", 2, "Maximum idle connections of the simulated DB connection pool.")
+	pongCmd.Flags().DurationVar(&dbPool.MaxIdleTime, "db-conn-max-idle-time", 0, "How long a simulated DB connection may be idle before it is closed. 0 means no limit.")
+	pongCmd.Flags().DurationVar(&dbPool.MaxLifetime, "db-conn-max-lifetime", 0, "How long a simulated DB connection may be reused. 0 means no limit.")
+	pongCmd.Flags().DurationVar(&dbPool.AcquireTimeout, "db-conn-acquire-timeout", time.Second, "How long a simulated DB query waits for a free connection before failing with pool_exhausted. 0 waits until the request is cancelled.")
+	pongCmd.Flags().DurationVar(&dbPool.ConnectLatency, "db-connect-latency", 5*time.Millisecond, "Latency added to simulated DB queries that have to open a new connection.")
+	pongCmd.Flags().StringVar(&dbFailPolicy, "db-failure-policy", dbPolicyFail, "How simulated DB errors affect ping responses. One of: [fail, retry, degrade]. fail responds 504 for timeouts, 503 for connection errors and 409 for deadlocks; retry retries retryable errors before failing; degrade responds successfully with the "+degradedHeader+" header set.")
+	pongCmd.Flags().IntVar(&dbRetries, "db-retries", 2, "How often retryable simulated DB errors are retried with the retry failure policy.")
+	pongCmd.Flags().DurationVar(&dbRetryWait, "db-retry-backoff", 10*time.Millisecond, "Initial backoff between simulated DB retries, doubled on every retry.")
+	pongCmd.Flags().BoolVar(&dbTx, "db-tx", false, "Run the simulated DB query plan of each request in a transaction. Without a configured plan, runs a payment-like transaction (select, insert, update, commit) instead of a single select.")
+	pongCmd.Flags().Float64Var(&dbTxConflict, "db-tx-conflict-prob", 0, "The probability (in %) of a simulated DB transaction failing to commit with a serialization failure.")
+	pongCmd.Flags().StringVar(&dbState.Transitions, "db-state-transitions", "", "Transitions between healthy, degraded and down simulated DB states as a Markov chain in format: <from>:<to>=<mean duration>,... e.g. healthy:down=10m,down:healthy=30s for 30s outages every 10m on average.")
+	pongCmd.Flags().StringVar(&dbState.Outages, "db-outages", "", "Scheduled simulated DB state windows since start in format: <state>@<start>+<duration>[/<every>],... e.g. down@1m+30s/5m. They override --db-state-transitions.")
+	pongCmd.Flags().Float64Var(&dbState.DegradedLatencyFactor, "db-degraded-latency-factor", 5, "Factor simulated DB query latency is multiplied with while the DB is degraded.")
+	pongCmd.Flags().Float64Var(&dbState.DegradedSuccessProb, "db-degraded-success-prob", 50, "The probability (in %) of a successful simulated DB query while the DB is degraded, if lower than otherwise.")
+	pongCmd.Flags().IntVar(&dbCapacity.Workers, "db-capacity-workers", 0, "Number of simulated DB queries executed concurrently, further queries queue. 0 disables the capacity model.")
+	pongCmd.Flags().IntVar(&dbCapacity.QueueSize, "db-capacity-queue-size", 100, "Number of simulated DB queries that may queue for a worker before failing as overloaded.")
+	pongCmd.Flags().DurationVar(&dbCapacity.QueueTimeout, "db-capacity-queue-timeout", 0, "How long a simulated DB query may queue for a worker before failing as overloaded. 0 waits until the request is cancelled.")
 
 	// ping command flags
 	pingCmd.Flags().StringVar(&pingAddr, "listen-address", ":8080", "The address to listen on for HTTP requests.")
 	pingCmd.Flags().StringVar(&endpoint, "endpoint", "http://localhost:8080/ping", "The address of pong app we can connect to and send requests.")
 	pingCmd.Flags().IntVar(&pingsPerSec, "pings-per-second", 10, "How many pings per second we should request")
+	pingCmd.Flags().StringVar(&httpProto, "http-proto", "auto", "HTTP protocol used to send pings. One of: [auto, http1, h2, h2c]. auto negotiates h2 over TLS and falls back to HTTP/1.1.")
+	pingCmd.Flags().StringArrayVar(&headers, "request-header", nil, "Header sent with pings in format: <name>=<value>[|<value>...], a random value is sent with every ping, e.g. X-Tenant=acme|globex|initech. Can be repeated.")
+
+	// ping connection flags
+	pingCmd.Flags().DurationVar(&keepAlive, "keep-alive", 30*time.Second, "TCP keep-alive period for connections to the pong server. A negative value disables TCP keep-alives.")
+	pingCmd.Flags().IntVar(&maxIdleConnsPerHost, "max-idle-conns-per-host", http.DefaultMaxIdleConnsPerHost, "Maximum idle connections kept for reuse per host.")
+	pingCmd.Flags().DurationVar(&idleConnTimeout, "idle-conn-timeout", 90*time.Second, "How long an idle connection is kept for reuse before being closed. Zero means no limit.")
+	pingCmd.Flags().BoolVar(&newConnPerRequest, "new-conn-per-request", false, "Open a new connection for every ping instead of reusing idle ones.")
+
+	// ping client TLS flags
+	pingCmd.Flags().StringVar(&endpointTLS.CertFile, "endpoint-tls-cert", "", "Path to the client certificate presented to the pong server for mTLS. Reloaded on change.")
+	pingCmd.Flags().StringVar(&endpointTLS.KeyFile, "endpoint-tls-key", "", "Path to the client key presented to the pong server for mTLS. Reloaded on change.")
+	pingCmd.Flags().StringVar(&endpointTLS.CAFile, "endpoint-tls-ca", "", "Path to the CA bundle used to verify the pong server. System roots are used if empty. Reloaded on change.")
+	pingCmd.Flags().StringVar(&endpointTLS.ServerName, "endpoint-tls-server-name", "", "Server name used to verify the pong server certificate.")
+	pingCmd.Flags().BoolVar(&endpointTLS.InsecureSkipVerify, "endpoint-tls-insecure-skip-verify", false, "Skip verification of the pong server certificate.")
+
+	// server TLS flags
+	for _, cmd := range []*cobra.Command{pongCmd, pingCmd} {
+		cmd.Flags().StringVar(&serverTLS.CertFile, "tls-cert", "", "Path to the TLS serving certificate. Enables HTTPS. Reloaded on change.")
+		cmd.Flags().StringVar(&serverTLS.KeyFile, "tls-key", "", "Path to the TLS serving key. Reloaded on change.")
+		cmd.Flags().StringVar(&serverTLS.ClientCAFile, "tls-client-ca", "", "Path to the CA bundle used to verify client certificates. Enables mTLS. Reloaded on change.")
+		cmd.Flags().BoolVar(&serverTLS.SelfSigned, "tls-self-signed", false, "Serve HTTPS with a certificate signed by an ephemeral CA generated at startup. For local testing only.")
+		cmd.Flags().StringVar(&serverTLS.SelfSignedCAFile, "tls-self-signed-ca-file", "", "Path to write the ephemeral CA certificate to when --tls-self-signed is set, so clients can trust it.")
+		cmd.Flags().DurationVar(&serverTLS.SelfSignedValidity, "tls-self-signed-validity", 365*24*time.Hour, "How long the ephemeral CA and certificate of --tls-self-signed are valid.")
+	}
 
-	rootCmd.AddCommand(pongCmd)
-	rootCmd.AddCommand(pingCmd)
-}
-
-func main() {
-	if err := rootCmd.Execute(); err != nil {
-		slog.Debug("command execution failed", "error", err)
-		os.Exit(1)
+	// metrics push flags
+	for _, cmd := range []*cobra.Command{pongCmd, pingCmd} {
+		cmd.Flags().StringVar(&remoteWriteURL, "push-remote-write-url", "", "Prometheus remote-write URL to push metrics to, e.g. http://localhost:9090/api/v1/write. Disabled if empty.")
+		cmd.Flags().StringVar(&otlpURL, "push-otlp-url", "", "OTLP endpoint to push metrics to, e.g. http://localhost:4318 for http or http://localhost:4317 for grpc. An http scheme disables TLS, http URLs without a path push to /v1/metrics. Disabled if empty.")
+		cmd.Flags().StringVar(&otlpProtocol, "push-otlp-protocol", extpush.ProtocolHTTP, "OTLP protocol to push metrics with. One of: [http, grpc].")
+		cmd.Flags().DurationVar(&pushInterval, "push-interval", 15*time.Second, "Interval metrics are pushed in. Metrics are pushed one last time on shutdown.")
+		cmd.Flags().DurationVar(&pushTimeout, "push-timeout", 10*time.Second, "Timeout of a metrics push. Bounds all retries of OTLP pushes, and each attempt of remote-write pushes.")
+		cmd.Flags().StringToStringVar(&pushExternalLabels, "push-external-labels", nil, "Labels added to all pushed series in format: <name>=<value>,... e.g. job=ci,run=123. Added as resource attributes to OTLP pushes.")
+		cmd.Flags().StringToStringVar(&pushHeaders, "push-headers", nil, "Headers sent with every metrics push in format: <name>=<value>,... e.g. X-Scope-OrgID=tenant.")
+		cmd.Flags().IntVar(&pushRetry.MaxRetries, "push-max-retries", 3, "How often a metrics push failing with a network error, 429 or 5xx is retried before it is dropped.")
+		cmd.Flags().DurationVar(&pushRetry.MinBackoff, "push-min-backoff", 100*time.Millisecond, "Initial backoff between metrics push retries, doubled on every retry.")
+		cmd.Flags().DurationVar(&pushRetry.MaxBackoff, "push-max-backoff", 5*time.Second, "Maximum backoff between metrics push retries.")
 	}
-}
 
-type latencyDecider struct {
-	latencies     []time.Duration
-	probabilities []float64 // Sorted ascending.
-}
+	// probe flags
+	for _, cmd := range []*cobra.Command{pongCmd, pingCmd} {
+		cmd.Flags().DurationVar(&probeOpts.StartupDelay, "probe-startup-delay", 0, "How long /startup and /ready fail after start, simulating a slow startup.")
+		cmd.Flags().DurationVar(&probeOpts.LivenessFailAfter, "probe-liveness-fail-after", 0, "Fail /healthz this long after start, simulating a deadlocked process for liveness probes to restart. 0 disables it.")
+		cmd.Flags().DurationVar(&probeOpts.ReadinessFlapInterval, "probe-readiness-flap-interval", 0, "Alternate /ready between passing and failing in this interval once started, simulating flapping readiness. 0 disables it.")
+	}
 
-func newLatencyDecider(encodedLatencies string) (*latencyDecider, error) {
-	l := latencyDecider{}
+	// shutdown flags
+	for _, cmd := range []*cobra.Command{pongCmd, pingCmd} {
+		cmd.Flags().DurationVar(&shutdownDelay, "shutdown-delay", 0, "How long /ready fails before shutting down on SIGINT or SIGTERM, while requests are still served, e.g. to let Kubernetes remove the pod from endpoints.")
+		cmd.Flags().DurationVar(&shutdownDrainTimeout, "shutdown-drain-timeout", 30*time.Second, "How long in-flight requests, and pings sent by ping, may take to finish on shutdown before they are cancelled.")
+	}
 
-	s := strings.Split(encodedLatencies, ",")
-	sort.Strings(s)
+	// debug flags
+	for _, cmd := range []*cobra.Command{pongCmd, pingCmd} {
+		cmd.Flags().BoolVar(&debugEnabled, "debug-endpoints", false, "Serve pprof profiles on /debug/pprof/, the effective configuration on /debug/config and build information on /version.")
+		cmd.Flags().StringVar(&debugListenAddress, "debug-listen-address", "", "The address to serve debug endpoints on, separate from the traffic port. Served on --listen-address if empty.")
+	}
 
-	cumulativeProb := 0.0
-	for _, e := range s {
-		entry := strings.Split(e, "%")
-		if len(entry) != 2 {
-			return nil, errors.Errorf("invalid input %v", encodedLatencies)
-		}
-		f, err := strconv.ParseFloat(entry[0], 64)
-		if err != nil {
-			return nil, errors.Wrapf(err, "parse probabilty %v as float", entry[0])
-		}
-		cumulativeProb += f
-		l.probabilities = append(l.probabilities, f)
+	// access log flags
+	for _, cmd := range []*cobra.Command{pongCmd, pingCmd} {
+		cmd.Flags().BoolVar(&accessLog, "access-log", false, "Log a structured access log of HTTP server requests with method, path, handler, status, latency, bytes in and out, remote address, user agent and trace ID.")
+		cmd.Flags().StringVar(&accessLogSampleRates, "access-log-sample-rates", "", "Encoded probability of logging requests by status class in format: <probability>%<class>,... e.g. 1%2xx,100%5xx. Classes not listed are always logged.")
+		cmd.Flags().DurationVar(&accessLogOpts.SlowThreshold, "access-log-slow-threshold", 0, "Latency at which requests are logged as slow at warn level, regardless of sampling. 0 disables it.")
+	}
 
-		d, err := time.ParseDuration(entry[1])
-		if err != nil {
-			return nil, errors.Wrapf(err, "parse latency %v as duration", entry[1])
-		}
-		l.latencies = append(l.latencies, d)
+	// cardinality stress flags
+	stressDefaults := extstress.DefaultOpts()
+	for _, cmd := range []*cobra.Command{pongCmd, pingCmd} {
+		cmd.Flags().BoolVar(&stressEnabled, "stress-enabled", false, "Expose synthetic series with configurable churn next to the regular metrics, to load-test the cardinality handling of TSDBs.")
+		cmd.Flags().IntVar(&stressOpts.Metrics, "stress-metrics", stressDefaults.Metrics, "Number of synthetic metric names.")
+		cmd.Flags().StringVar(&stressOpts.Type, "stress-metric-type", stressDefaults.Type, "Type of synthetic metrics. One of: [gauge, counter, histogram]. Histograms have 14 series per pod.")
+		cmd.Flags().IntVar(&stressOpts.Pods, "stress-pods", stressDefaults.Pods, "Number of values of the pod label of synthetic metrics, i.e. series per gauge or counter metric.")
+		cmd.Flags().IntVar(&stressOpts.ExtraLabels, "stress-extra-labels", stressDefaults.ExtraLabels, "Number of extra labels with static values on synthetic series, increasing their size but not their number.")
+		cmd.Flags().Float64Var(&stressOpts.ChurnRate, "stress-churn-rate", stressDefaults.ChurnRate, "Number of pods replaced per second, deleting their synthetic series and creating new ones to simulate pod churn.")
+		cmd.Flags().IntVar(&stressOpts.BurstPods, "stress-burst-pods", stressDefaults.BurstPods, "Number of pods added for --stress-burst-duration every --stress-burst-interval, to simulate bursts of new series. 0 disables bursts.")
+		cmd.Flags().DurationVar(&stressOpts.BurstInterval, "stress-burst-interval", stressDefaults.BurstInterval, "Interval bursts of synthetic series start in, the first one after an interval.")
+		cmd.Flags().DurationVar(&stressOpts.BurstDuration, "stress-burst-duration", stressDefaults.BurstDuration, "How long the synthetic series of a burst exist.")
+		cmd.Flags().IntVar(&stressOpts.MaxSeries, "stress-max-series", stressDefaults.MaxSeries, "Maximum number of synthetic series. Configurations exceeding it fail, bursts exceeding it are clipped. 0 means no limit.")
 	}
-	if cumulativeProb != 100 {
-		return nil, errors.Errorf("overall probability has to equal 100. Parsed input equals to %v", cumulativeProb)
+
+	// SLO flags
+	for _, flags := range []*pflag.FlagSet{pongCmd.Flags(), generateCmd.PersistentFlags()} {
+		flags.Float64Var(&sloAvailability, "slo-availability", 99.9, "Target availability (in %) of pong, the ratio of requests not failing with 5xx.")
+		flags.DurationVar(&sloLatency, "slo-latency", 600*time.Millisecond, "Latency threshold of pong's latency SLO. Generated rules require it to be a bucket boundary of http_request_duration_seconds.")
+		flags.Float64Var(&sloLatencyTarget, "slo-latency-target", 99, "Target ratio (in %) of pong requests served faster than --slo-latency.")
 	}
-	slog.Info("latency decider created", "latencies", l.latencies, "probabilities", l.probabilities)
-	return &l, nil
+
+	rootCmd.AddCommand(pongCmd)
+	rootCmd.AddCommand(pingCmd)
 }
 
-func (l latencyDecider) AddLatency(ctx context.Context) {
-	n := rand.Float64() * 100
-	for i, p := range l.probabilities {
-		if n <= p {
-			<-time.After(l.latencies[i])
-			return
-		}
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		slog.Debug("command execution failed", "error", err)
+		os.Exit(1)
 	}
 }
 
 func handlerPing(w http.ResponseWriter, r *http.Request) {
-	latDecider.AddLatency(r.Context())
+	latency, _ := latencies.Pick()
+	<-time.After(latency)
 
 	// Simulate database query if enabled
+	degraded := false
 	if dbSimulator != nil {
-		// Simulate a typical read operation (e.g., fetching user data)
-		result := dbSimulator.SimulateSelect(r.Context(), "users")
-		if !result.Success {
-			slog.Warn("simulated db query failed during ping",
-				"method", r.Method,
-				"path", r.URL.Path,
-				"error_type", result.ErrorType,
-			)
+		var ok bool
+		if degraded, ok = dbPolicy.Query(w, r, queryDB); !ok {
+			return
+		}
+	}
+
+	if queue != nil {
+		if _, err := queue.Publish(); err != nil {
+			slog.Warn("failed to publish message", "error", err)
 		}
 	}
 
+	if faults != nil && faults.Inject(w, r) {
+		return
+	}
+
+	// Degraded responses are served successfully, the DB error already stands in for the failure.
 	n := rand.Float64() * 100
-	if n <= successProb {
+	if degraded || n <= successProb {
 		slog.Debug("ping request succeeded", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
-		w.WriteHeader(200)
-		_, _ = fmt.Fprintln(w, "pong")
+		payload.Write(w, r)
 	} else {
-		slog.Warn("ping request failed", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "status", 500)
+		slog.WarnContext(r.Context(), "ping request failed", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "status", 500)
+		if logs != nil {
+			logs.Error(r.Context(), "ping request failed", slog.String("path", r.URL.Path), slog.Int("status", 500))
+		}
 		w.WriteHeader(500)
 	}
 }
 
-func runPongServer() (err error) {
+// gateHandler serves requests through the given gate, rejecting them with 503 when it is overloaded.
+func gateHandler(g *extgate.Gate, next http.Handler) http.Handler {
+	if g == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := g.Start(r.Context()); err != nil {
+			slog.Warn("ping request rejected", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "status", http.StatusServiceUnavailable, "error", err)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		defer g.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func runPongServer(cmd *cobra.Command) (err error) {
 	slog.Info("starting pong server", "build_info", version.Info(), "build_context", version.BuildContext())
 
-	latDecider, err = newLatencyDecider(lat)
+	latencies, err = extrand.NewWeightedChoice(lat, time.ParseDuration)
+	if err != nil {
+		return errors.Wrap(err, "parsing latency")
+	}
+
+	payload, err = newPayloadWriter(responseSizes, responseContentTypes, responseChunkSize, responseChunkDelay)
 	if err != nil {
 		return err
 	}
@@ -225,46 +488,192 @@ func runPongServer() (err error) {
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
 
+	faults, err = newFaultInjector(reg, faultsEncoded, faultDelay, faultAfterBytes)
+	if err != nil {
+		return err
+	}
+
+	if err := validateDBFailurePolicy(dbFailPolicy); err != nil {
+		return err
+	}
+
 	// Initialize database simulator if enabled
 	if dbEnabled {
+		dbCfg, err := loadDBConfig(dbConfigFile)
+		if err != nil {
+			return err
+		}
+		dbPlan = dbCfg.Plan
+
 		dbMetrics := extdb.NewMetrics(reg, nil)
 		dbSimulator, err = extdb.NewSimulator(dbMetrics, extdb.SimulatorOpts{
-			Latency:     dbLatency,
-			SuccessProb: dbSuccessProb,
-			ErrorTypes:  dbErrorTypes,
+			Latency:        dbLatency,
+			SuccessProb:    dbSuccessProb,
+			ErrorTypes:     dbErrorTypes,
+			Pool:           dbPool,
+			TxConflictProb: dbTxConflict,
+			Profiles:       dbCfg.Profiles,
+			State:          dbState,
+			Gate:           extgate.New(reg, "db", dbCapacity),
 		})
 		if err != nil {
 			return errors.Wrap(err, "creating database simulator")
 		}
+		dbPolicy, err = newDBErrorPolicy(reg, dbFailPolicy, dbRetries, dbRetryWait)
+		if err != nil {
+			return err
+		}
 		slog.Info("database simulation enabled",
 			"latency", dbLatency,
 			"success_prob", dbSuccessProb,
 			"error_types", dbErrorTypes,
+			"max_open_conns", dbPool.MaxOpen,
+			"capacity_workers", dbCapacity.Workers,
+			"failure_policy", dbFailPolicy,
+			"profiles", len(dbCfg.Profiles),
+			"plan", dbPlan,
+		)
+	}
+
+	if cacheEnabled {
+		if !dbEnabled {
+			return errors.New("--cache-enabled requires --db-enabled")
+		}
+		cache, err = extcache.New(extcache.NewMetrics(reg, nil), cacheOpts)
+		if err != nil {
+			return errors.Wrap(err, "creating cache simulator")
+		}
+		slog.Info("cache simulation enabled",
+			"latency", cacheOpts.Latency,
+			"hit_ratio", cacheOpts.HitRatio,
+			"keyspace", cacheOpts.Keyspace,
+			"capacity", cacheOpts.Capacity,
+			"ttl", cacheOpts.TTL,
+			"coalesce", cacheOpts.Coalesce,
+		)
+	}
+
+	if queueEnabled {
+		queue, err = extqueue.New(extqueue.NewMetrics(reg, nil), queueOpts)
+		if err != nil {
+			return errors.Wrap(err, "creating queue simulator")
+		}
+		slog.Info("queue simulation enabled",
+			"capacity", queueOpts.Capacity,
+			"consumers", queueOpts.Consumers,
+			"processing_latency", queueOpts.ProcessingLatency,
+			"success_prob", queueOpts.SuccessProb,
+			"max_deliveries", queueOpts.MaxDeliveries,
 		)
 	}
 
-	instr := exthttp.NewInstrumentationMiddleware(reg, []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120, 240, 360, 720})
+	if logsOutput != "" {
+		logsOpts.Output = logsOutput
+		logsOpts.ServiceName = "pong"
+		if logsOpts.MaxFileSize, err = parseSize(logsMaxFileSize); err != nil {
+			return errors.Wrap(err, "parsing synthetic log file max size")
+		}
+		logs, err = extlog.New(reg, logsOpts)
+		if err != nil {
+			return errors.Wrap(err, "creating synthetic log workload")
+		}
+	}
+
+	labels := make([]exthttp.ExtraLabel, 0, len(extraLabels))
+	for _, l := range extraLabels {
+		label, err := exthttp.ParseExtraLabel(l, extraLabelsMaxValues)
+		if err != nil {
+			return err
+		}
+		labels = append(labels, label)
+	}
+
+	instr, err := exthttp.NewInstrumentationMiddlewareWithOptions(reg,
+		exthttp.WithBuckets(httpDurationBuckets),
+		exthttp.WithExtraLabels(labels...),
+	)
+	if err != nil {
+		return err
+	}
+	instr, err = withAccessLog(instr)
+	if err != nil {
+		return err
+	}
 	m := http.NewServeMux()
 	m.Handle("/metrics", instr.NewHandler("/metrics", promhttp.HandlerFor(
 		reg,
 		promhttp.HandlerOpts{},
 	)))
-	m.Handle("/ping", instr.NewHandler("/ping", http.HandlerFunc(handlerPing)))
-	srv := http.Server{Addr: pongAddr, Handler: m}
+	slos, err := extslo.New(reg, extslo.Opts{
+		AvailabilityTarget: sloAvailability,
+		LatencyThreshold:   sloLatency,
+		LatencyTarget:      sloLatencyTarget,
+		Windows:            sloWindows,
+		BudgetWindow:       sloBudgetWindow,
+	})
+	if err != nil {
+		return errors.Wrap(err, "creating SLO tracker")
+	}
+	m.Handle("/slo", instr.NewHandler("/slo", slos))
+
+	gate := extgate.New(reg, "pong", capacity)
+	m.Handle("/ping", instr.NewHandler("/ping", slos.Handler("/ping", gateHandler(gate, http.HandlerFunc(handlerPing)))))
+	prober := extprobe.New(reg, probeOpts)
+	if dbSimulator != nil {
+		prober.AddReadinessCheck("db", func() error {
+			if state := dbSimulator.State(); state == extdb.StateDown {
+				return errors.Errorf("simulated DB is %v", state)
+			}
+			return nil
+		})
+	}
+	addProbeHandlers(m, instr, prober)
+
+	tlsCfg, err := exthttp.NewServerTLSConfig(serverTLS)
+	if err != nil {
+		return errors.Wrap(err, "creating server TLS config")
+	}
+	var handler http.Handler = m
+	if logs != nil {
+		handler = logs.AccessHandler(handler)
+	}
+	srv := http.Server{Addr: pongAddr, Handler: exthttp.ExtractTraceContext(handler), TLSConfig: tlsCfg, Protocols: exthttp.ServerProtocols(http2, h2c)}
 
 	g := &run.Group{}
+	addPreStop(g, prober)
 	g.Add(func() error {
-		slog.Info("starting HTTP server", "address", pongAddr, "mode", "pong")
-		if err := srv.ListenAndServe(); err != nil {
+		slog.Info("starting HTTP server", "address", pongAddr, "mode", "pong", "tls", tlsCfg != nil, "protocols", srv.Protocols)
+		if err := listenAndServe(&srv); err != nil {
 			return errors.Wrap(err, "starting web server")
 		}
 		return nil
 	}, func(error) {
-		slog.Info("shutting down HTTP server")
-		if err := srv.Close(); err != nil {
-			slog.Error("failed to stop web server", "error", err)
-		}
+		shutdownServer(&srv)
 	})
+	if logs != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return logs.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+	if err := addStressGenerator(g, reg); err != nil {
+		return err
+	}
+	if err := addPushers(g, reg, "pong"); err != nil {
+		return err
+	}
+	if queue != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return queue.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+	// Added last to keep serving profiles while shutting down.
+	addDebugEndpoints(g, m, instr, cmd)
 	g.Add(run.SignalHandler(context.Background(), syscall.SIGINT, syscall.SIGTERM))
 	err = g.Run()
 	var sigErr run.SignalError
@@ -275,7 +684,7 @@ func runPongServer() (err error) {
 	return err
 }
 
-func runPinger() (err error) {
+func runPinger(cmd *cobra.Command) (err error) {
 	slog.Info("starting pinger", "build_info", version.Info(), "build_context", version.BuildContext())
 
 	reg := prometheus.NewRegistry()
@@ -285,40 +694,94 @@ func runPinger() (err error) {
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
 
-	instr := exthttp.NewInstrumentationMiddleware(reg, []float64{0.001, 0.01, 0.1, 0.3, 0.6, 1, 3, 6, 9, 20, 30, 60, 90, 120, 240, 360, 720})
+	pingHeaders, err = parseRequestHeaders(headers)
+	if err != nil {
+		return err
+	}
+
+	instr, err := withAccessLog(exthttp.NewInstrumentationMiddleware(reg, httpDurationBuckets))
+	if err != nil {
+		return err
+	}
 	m := http.NewServeMux()
 	m.Handle("/metrics", instr.NewHandler("/metrics", promhttp.HandlerFor(
 		reg,
 		promhttp.HandlerOpts{},
 	)))
-	srv := http.Server{Addr: pingAddr, Handler: m}
+	prober := extprobe.New(reg, probeOpts)
+	addProbeHandlers(m, instr, prober)
+
+	tlsCfg, err := exthttp.NewServerTLSConfig(serverTLS)
+	if err != nil {
+		return errors.Wrap(err, "creating server TLS config")
+	}
+	srv := http.Server{Addr: pingAddr, Handler: m, TLSConfig: tlsCfg}
+
+	// Pong is verified by name, which is not sent for IP endpoints, so default it to the endpoint host.
+	clientTLS := endpointTLS
+	if u, err := url.Parse(endpoint); err == nil && clientTLS.ServerName == "" {
+		clientTLS.ServerName = u.Hostname()
+	}
+	clientTLSCfg, err := exthttp.NewClientTLSConfig(clientTLS)
+	if err != nil {
+		return errors.Wrap(err, "creating client TLS config")
+	}
+	clientProtocols, err := exthttp.ClientProtocols(httpProto)
+	if err != nil {
+		return err
+	}
 
 	g := &run.Group{}
-	g.Add(func() error {
-		slog.Info("starting HTTP server", "address", pingAddr, "mode", "ping")
-		if err := srv.ListenAndServe(); err != nil {
-			return errors.Wrap(err, "starting web server")
-		}
-		return nil
-	}, func(error) {
-		slog.Info("shutting down HTTP server")
-		if err := srv.Close(); err != nil {
-			slog.Error("failed to stop web server", "error", err)
-		}
-	})
+	addPreStop(g, prober)
 	{
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = clientTLSCfg
+		transport.Protocols = clientProtocols
+		transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: keepAlive}).DialContext
+		transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		transport.IdleConnTimeout = idleConnTimeout
+		transport.DisableKeepAlives = newConnPerRequest
 		client := &http.Client{
-			Transport: exthttp.InstrumentedRoundTripper(http.DefaultTransport, exthttp.NewClientMetrics(reg)),
+			Transport: exthttp.InstrumentedRoundTripper(transport, exthttp.NewClientMetrics(reg)),
 		}
 
+		// Pings are stopped before the server and pushers, so in-flight pings are reflected
+		// in the final scrape and push.
 		ctx, cancel := context.WithCancel(context.Background())
+		reqCtx, reqCancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
 		g.Add(func() error {
-			spamPings(ctx, client, endpoint, pingsPerSec)
+			defer close(done)
+			spamPings(ctx, reqCtx, client, endpoint, pingsPerSec)
 			return nil
 		}, func(error) {
 			cancel()
+			select {
+			case <-done:
+			case <-time.After(shutdownDrainTimeout):
+				slog.Warn("in-flight pings did not finish in time, cancelling them", "drain_timeout", shutdownDrainTimeout)
+			}
+			reqCancel()
+			<-done
 		})
 	}
+	g.Add(func() error {
+		slog.Info("starting HTTP server", "address", pingAddr, "mode", "ping", "tls", tlsCfg != nil)
+		if err := listenAndServe(&srv); err != nil {
+			return errors.Wrap(err, "starting web server")
+		}
+		return nil
+	}, func(error) {
+		shutdownServer(&srv)
+	})
+	if err := addStressGenerator(g, reg); err != nil {
+		return err
+	}
+	if err := addPushers(g, reg, "ping"); err != nil {
+		return err
+	}
+	// Added last to keep serving profiles while shutting down.
+	addDebugEndpoints(g, m, instr, cmd)
 	g.Add(run.SignalHandler(context.Background(), syscall.SIGINT, syscall.SIGTERM))
 	err = g.Run()
 	var sigErr run.SignalError
@@ -329,12 +792,61 @@ func runPinger() (err error) {
 	return err
 }
 
-func spamPings(ctx context.Context, client *http.Client, endpoint string, pingsPerSec int) {
+// addStressGenerator adds the synthetic series generator to the run group, if enabled.
+func addStressGenerator(g *run.Group, reg *prometheus.Registry) error {
+	if !stressEnabled {
+		return nil
+	}
+	gen, err := extstress.New(reg, extstress.NewMetrics(reg), stressOpts)
+	if err != nil {
+		return errors.Wrap(err, "creating synthetic series generator")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return gen.Run(ctx)
+	}, func(error) {
+		cancel()
+	})
+	return nil
+}
+
+// addProbeHandlers serves the probes of prober on /healthz, /ready and /startup.
+func addProbeHandlers(m *http.ServeMux, instr exthttp.InstrumentationMiddleware, prober *extprobe.Prober) {
+	m.Handle("/healthz", instr.NewHandler("/healthz", prober.LivenessHandler()))
+	m.Handle("/ready", instr.NewHandler("/ready", prober.ReadinessHandler()))
+	m.Handle("/startup", instr.NewHandler("/startup", prober.StartupHandler()))
+}
+
+// withAccessLog wraps instr with the access log middleware, if enabled.
+func withAccessLog(instr exthttp.InstrumentationMiddleware) (exthttp.InstrumentationMiddleware, error) {
+	if !accessLog {
+		return instr, nil
+	}
+	rates, err := exthttp.ParseAccessLogSampleRates(accessLogSampleRates)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing access log sample rates")
+	}
+	accessLogOpts.SampleRates = rates
+	return exthttp.NewAccessLogMiddleware(instr, slog.Default(), accessLogOpts), nil
+}
+
+// listenAndServe starts the given server, serving HTTPS if a TLS config is set.
+func listenAndServe(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
+}
+
+// spamPings sends pings until ctx is done, then waits for in-flight pings, which are
+// cancelled with reqCtx.
+func spamPings(ctx, reqCtx context.Context, client *http.Client, endpoint string, pingsPerSec int) {
 	slog.Info("starting ping spam", "endpoint", endpoint, "pings_per_sec", pingsPerSec)
 	var wg sync.WaitGroup
 	for {
 		select {
 		case <-ctx.Done():
+			slog.Info("stopped sending pings, waiting for in-flight pings")
 			wg.Wait()
 			return
 		case <-time.After(1 * time.Second):
@@ -342,7 +854,7 @@ func spamPings(ctx context.Context, client *http.Client, endpoint string, pingsP
 
 		for range pingsPerSec {
 			wg.Add(1)
-			go ping(ctx, client, endpoint, &wg)
+			go ping(reqCtx, client, endpoint, &wg)
 		}
 	}
 }
@@ -358,6 +870,9 @@ func ping(ctx context.Context, client *http.Client, endpoint string, wg *sync.Wa
 		slog.Error("failed to create request", "error", err, "endpoint", endpoint)
 		return
 	}
+	for _, h := range pingHeaders {
+		r.Header.Set(h.name, h.values[rand.Intn(len(h.values))])
+	}
 	res, err := client.Do(r)
 	if err != nil {
 		slog.Error("failed to send request", "error", err, "endpoint", endpoint)
@@ -370,3 +885,21 @@ func ping(ctx context.Context, client *http.Client, endpoint string, wg *sync.Wa
 		_ = res.Body.Close()
 	}
 }
+
+// requestHeader is a header sent with pings, with a random value out of values.
+type requestHeader struct {
+	name   string
+	values []string
+}
+
+func parseRequestHeaders(encoded []string) ([]requestHeader, error) {
+	hs := make([]requestHeader, 0, len(encoded))
+	for _, e := range encoded {
+		name, values, ok := strings.Cut(e, "=")
+		if !ok || name == "" {
+			return nil, errors.Errorf("invalid request header %q, expected <name>=<value>[|<value>...]", e)
+		}
+		hs = append(hs, requestHeader{name: name, values: strings.Split(values, "|")})
+	}
+	return hs, nil
+}
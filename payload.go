@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/pkg/errors"
+	"github.com/saswatamcode/pingpong/extrand"
+)
+
+// pongBody is the default response body, repeated to fill larger payloads.
+const pongBody = "pong\n"
+
+// payloadFiller is the block response bodies are cut from.
+var payloadFiller = []byte(strings.Repeat(pongBody, 64*1024/len(pongBody)))
+
+// payloadWriter writes successful pong responses with simulated size,
+// content type and delivery speed.
+type payloadWriter struct {
+	sizes        *extrand.WeightedChoice[int64]
+	contentTypes *extrand.WeightedChoice[string]
+	chunkSize    int64
+	chunkDelay   time.Duration
+}
+
+// newPayloadWriter creates a payloadWriter. Empty sizes and content types
+// default to the plain "pong" body. A zero chunk size writes the body at once.
+func newPayloadWriter(sizes, contentTypes, chunkSize string, chunkDelay time.Duration) (*payloadWriter, error) {
+	p := &payloadWriter{chunkDelay: chunkDelay}
+
+	if sizes == "" {
+		sizes = "100%" + strconv.Itoa(len(pongBody)) + "B"
+	}
+	var err error
+	p.sizes, err = extrand.NewWeightedChoice(sizes, parseSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing response sizes")
+	}
+
+	if contentTypes == "" {
+		contentTypes = "100%text/plain; charset=utf-8"
+	}
+	p.contentTypes, err = extrand.NewWeightedChoice(contentTypes, func(s string) (string, error) {
+		if s == "" {
+			return "", errors.New("empty content type")
+		}
+		return s, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing response content types")
+	}
+
+	if chunkSize != "" {
+		p.chunkSize, err = parseSize(chunkSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing response chunk size")
+		}
+	}
+	if p.chunkSize == 0 && chunkDelay > 0 {
+		return nil, errors.New("response chunk delay requires a response chunk size")
+	}
+
+	slog.Info("payload writer created", "sizes", p.sizes.Values(), "content_types", p.contentTypes.Values(), "chunk_size", p.chunkSize, "chunk_delay", chunkDelay)
+	return p, nil
+}
+
+func parseSize(s string) (int64, error) {
+	b, err := units.ParseBase2Bytes(s)
+	if err != nil {
+		return 0, err
+	}
+	if b < 0 {
+		return 0, errors.Errorf("negative size %v", s)
+	}
+	return int64(b), nil
+}
+
+// Write writes a successful response. If chunking is enabled the body is streamed,
+// flushing every chunk and waiting the chunk delay in between.
+func (p *payloadWriter) Write(w http.ResponseWriter, r *http.Request) {
+	size, _ := p.sizes.Pick()
+	contentType, _ := p.contentTypes.Pick()
+
+	w.Header().Set("Content-Type", contentType)
+	if p.chunkSize == 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	for written := int64(0); written < size; {
+		n := size - written
+		if p.chunkSize > 0 && n > p.chunkSize {
+			n = p.chunkSize
+		}
+		if err := writeFiller(w, n); err != nil {
+			slog.Debug("failed to write response body", "error", err, "written", written, "size", size)
+			return
+		}
+		written += n
+
+		if p.chunkSize == 0 || written >= size {
+			continue
+		}
+		if err := rc.Flush(); err != nil {
+			slog.Debug("failed to flush response chunk", "error", err)
+		}
+		if p.chunkDelay > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(p.chunkDelay):
+			}
+		}
+	}
+}
+
+// writeFiller writes n bytes of the repeated pong body.
+func writeFiller(w http.ResponseWriter, n int64) error {
+	for n > 0 {
+		b := payloadFiller
+		if int64(len(b)) > n {
+			b = b[:n]
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		n -= int64(len(b))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/saswatamcode/pingpong/extpush"
+)
+
+// addPushers adds the configured metrics push exporters of the registry to the run group.
+func addPushers(g *run.Group, reg *prometheus.Registry, serviceName string) error {
+	if remoteWriteURL == "" && otlpURL == "" {
+		return nil
+	}
+	metrics := extpush.NewMetrics(reg)
+
+	if remoteWriteURL != "" {
+		w, err := extpush.NewRemoteWriter(reg, metrics, extpush.RemoteWriteOpts{
+			URL:            remoteWriteURL,
+			Interval:       pushInterval,
+			Timeout:        pushTimeout,
+			ExternalLabels: pushExternalLabels,
+			Headers:        pushHeaders,
+			Retry:          pushRetry,
+		})
+		if err != nil {
+			return errors.Wrap(err, "creating remote-write pusher")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return w.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if otlpURL != "" {
+		e, err := extpush.NewOTLPExporter(context.Background(), reg, metrics, serviceName, extpush.OTLPOpts{
+			URL:            otlpURL,
+			Protocol:       otlpProtocol,
+			Interval:       pushInterval,
+			Timeout:        pushTimeout,
+			ExternalLabels: pushExternalLabels,
+			Headers:        pushHeaders,
+			Retry:          pushRetry,
+		})
+		if err != nil {
+			return errors.Wrap(err, "creating OTLP pusher")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return e.Run(ctx)
+		}, func(error) {
+			cancel()
+		})
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/oklog/run"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/saswatamcode/pingpong/extpush"
+	"github.com/spf13/cobra"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricpb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// receive command flags
+	receiveAddr     string
+	receiveGRPCAddr string
+	receiveFailProb float64
+)
+
+var receiveCmd = &cobra.Command{
+	Use:   "receive",
+	Short: "Start a receiver for pushed metrics and logs",
+	Long:  "Start a stand-in receiver accepting Prometheus remote-write and OTLP metrics pushed by ping and pong, and OTLP logs of pong's synthetic log workload, logging what it receives. For local testing only, received data is not stored.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runReceiver()
+	},
+}
+
+func init() {
+	// receive command flags
+	receiveCmd.Flags().StringVar(&receiveAddr, "listen-address", ":9091", "The address to listen on for remote-write on /api/v1/write, OTLP/HTTP on /v1/metrics and /v1/logs, and the receiver's own /metrics.")
+	receiveCmd.Flags().StringVar(&receiveGRPCAddr, "grpc-listen-address", "", "The address to listen on for OTLP/gRPC, e.g. :4317. Disabled if empty.")
+	receiveCmd.Flags().Float64Var(&receiveFailProb, "fail-prob", 0, "The probability (in %) of rejecting a push with a retryable error (503 or Unavailable), to exercise retries.")
+
+	rootCmd.AddCommand(receiveCmd)
+}
+
+// receiver counts pushed metrics.
+type receiver struct {
+	requests *prometheus.CounterVec
+	samples  *prometheus.CounterVec
+}
+
+func newReceiver(reg prometheus.Registerer) *receiver {
+	return &receiver{
+		requests: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "receiver",
+			Name:      "requests_total",
+			Help:      "Total number of received push requests.",
+		}, []string{"protocol", "result"}), // result: success, rejected, invalid
+		samples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Subsystem: "receiver",
+			Name:      "samples_total",
+			Help:      "Total number of received samples, data points for OTLP metrics or records for OTLP logs.",
+		}, []string{"protocol"}),
+	}
+}
+
+// reject reports whether to reject a push to exercise retries.
+func (rc *receiver) reject(protocol string) bool {
+	if rand.Float64()*100 >= receiveFailProb {
+		return false
+	}
+	rc.requests.WithLabelValues(protocol, "rejected").Inc()
+	return true
+}
+
+func (rc *receiver) handleRemoteWrite(w http.ResponseWriter, r *http.Request) {
+	const protocol = "remote_write"
+	if rc.reject(protocol) {
+		http.Error(w, "rejected to exercise retries", http.StatusServiceUnavailable)
+		return
+	}
+
+	series, err := func() ([]extpush.TimeSeries, error) {
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "read body")
+		}
+		b, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode snappy")
+		}
+		return extpush.DecodeWriteRequest(b)
+	}()
+	if err != nil {
+		rc.requests.WithLabelValues(protocol, "invalid").Inc()
+		slog.Warn("invalid remote-write push", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	samples := 0
+	for _, ts := range series {
+		samples += len(ts.Samples)
+		slog.Debug("received series", "protocol", protocol, "labels", ts.Labels, "samples", ts.Samples)
+	}
+	rc.requests.WithLabelValues(protocol, "success").Inc()
+	rc.samples.WithLabelValues(protocol).Add(float64(samples))
+	slog.Info("received remote-write push", "series", len(series), "samples", samples, "user_agent", r.UserAgent())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rc *receiver) handleOTLPHTTP(w http.ResponseWriter, r *http.Request) {
+	const protocol = "otlp_http"
+	req := &colmetricpb.ExportMetricsServiceRequest{}
+	if !rc.decodeOTLPHTTP(w, r, protocol, req) {
+		return
+	}
+	rc.recordOTLP(protocol, req)
+	writeOTLPHTTP(w, r, &colmetricpb.ExportMetricsServiceResponse{})
+}
+
+func (rc *receiver) handleOTLPLogs(w http.ResponseWriter, r *http.Request) {
+	const protocol = "otlp_http_logs"
+	req := &collogspb.ExportLogsServiceRequest{}
+	if !rc.decodeOTLPHTTP(w, r, protocol, req) {
+		return
+	}
+
+	total := 0
+	for _, rl := range req.GetResourceLogs() {
+		records := 0
+		attrs := resourceAttrs(rl.GetResource().GetAttributes())
+		for _, sl := range rl.GetScopeLogs() {
+			for _, lr := range sl.GetLogRecords() {
+				records++
+				slog.Debug("received log record", "protocol", protocol, "resource", attrs, "severity", lr.GetSeverityText(), "body", lr.GetBody().GetStringValue(), "trace_id", hex.EncodeToString(lr.GetTraceId()), "span_id", hex.EncodeToString(lr.GetSpanId()))
+			}
+		}
+		slog.Info("received OTLP logs push", "protocol", protocol, "resource", attrs, "records", records)
+		total += records
+	}
+	rc.requests.WithLabelValues(protocol, "success").Inc()
+	rc.samples.WithLabelValues(protocol).Add(float64(total))
+	writeOTLPHTTP(w, r, &collogspb.ExportLogsServiceResponse{})
+}
+
+// decodeOTLPHTTP decodes an OTLP/HTTP request in JSON or protobuf encoding into req. It reports
+// whether the request was accepted, responding with an error otherwise.
+func (rc *receiver) decodeOTLPHTTP(w http.ResponseWriter, r *http.Request, protocol string, req proto.Message) bool {
+	if rc.reject(protocol) {
+		http.Error(w, "rejected to exercise retries", http.StatusServiceUnavailable)
+		return false
+	}
+
+	err := func() error {
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				return errors.Wrap(err, "decode gzip")
+			}
+			body = gz
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return errors.Wrap(err, "read body")
+		}
+		if isJSON(r) {
+			return errors.Wrap(protojson.Unmarshal(b, req), "unmarshal json")
+		}
+		return errors.Wrap(proto.Unmarshal(b, req), "unmarshal protobuf")
+	}()
+	if err != nil {
+		rc.requests.WithLabelValues(protocol, "invalid").Inc()
+		slog.Warn("invalid OTLP push", "protocol", protocol, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeOTLPHTTP writes an OTLP/HTTP response in the encoding of the request.
+func writeOTLPHTTP(w http.ResponseWriter, r *http.Request, resp proto.Message) {
+	var b []byte
+	if isJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		b, _ = protojson.Marshal(resp)
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		b, _ = proto.Marshal(resp)
+	}
+	_, _ = w.Write(b)
+}
+
+func isJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+}
+
+// otlpGRPCReceiver receives OTLP/gRPC metrics pushes.
+type otlpGRPCReceiver struct {
+	colmetricpb.UnimplementedMetricsServiceServer
+	*receiver
+}
+
+func (rc otlpGRPCReceiver) Export(_ context.Context, req *colmetricpb.ExportMetricsServiceRequest) (*colmetricpb.ExportMetricsServiceResponse, error) {
+	const protocol = "otlp_grpc"
+	if rc.reject(protocol) {
+		return nil, status.Error(codes.Unavailable, "rejected to exercise retries")
+	}
+	rc.recordOTLP(protocol, req)
+	return &colmetricpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (rc *receiver) recordOTLP(protocol string, req *colmetricpb.ExportMetricsServiceRequest) {
+	total := 0
+	for _, rm := range req.GetResourceMetrics() {
+		metrics, points := 0, 0
+		attrs := resourceAttrs(rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				metrics++
+				points += otlpDataPoints(m)
+				slog.Debug("received metric", "protocol", protocol, "resource", attrs, "name", m.GetName(), "data_points", otlpDataPoints(m))
+			}
+		}
+		slog.Info("received OTLP push", "protocol", protocol, "resource", attrs, "metrics", metrics, "data_points", points)
+		total += points
+	}
+	rc.requests.WithLabelValues(protocol, "success").Inc()
+	rc.samples.WithLabelValues(protocol).Add(float64(total))
+}
+
+func resourceAttrs(kvs []*commonpb.KeyValue) []string {
+	attrs := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		attrs = append(attrs, kv.GetKey()+"="+kv.GetValue().GetStringValue())
+	}
+	return attrs
+}
+
+func otlpDataPoints(m *metricpb.Metric) int {
+	switch d := m.GetData().(type) {
+	case *metricpb.Metric_Gauge:
+		return len(d.Gauge.GetDataPoints())
+	case *metricpb.Metric_Sum:
+		return len(d.Sum.GetDataPoints())
+	case *metricpb.Metric_Histogram:
+		return len(d.Histogram.GetDataPoints())
+	case *metricpb.Metric_ExponentialHistogram:
+		return len(d.ExponentialHistogram.GetDataPoints())
+	case *metricpb.Metric_Summary:
+		return len(d.Summary.GetDataPoints())
+	}
+	return 0
+}
+
+func runReceiver() error {
+	slog.Info("starting metrics receiver")
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	rc := newReceiver(reg)
+
+	m := http.NewServeMux()
+	m.HandleFunc("POST /api/v1/write", rc.handleRemoteWrite)
+	m.HandleFunc("POST /v1/metrics", rc.handleOTLPHTTP)
+	m.HandleFunc("POST /v1/logs", rc.handleOTLPLogs)
+	m.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	srv := http.Server{Addr: receiveAddr, Handler: m}
+
+	g := &run.Group{}
+	g.Add(func() error {
+		slog.Info("starting HTTP server", "address", receiveAddr, "mode", "receive")
+		if err := srv.ListenAndServe(); err != nil {
+			return errors.Wrap(err, "starting web server")
+		}
+		return nil
+	}, func(error) {
+		slog.Info("shutting down HTTP server")
+		if err := srv.Close(); err != nil {
+			slog.Error("failed to stop web server", "error", err)
+		}
+	})
+	if receiveGRPCAddr != "" {
+		grpcSrv := grpc.NewServer()
+		colmetricpb.RegisterMetricsServiceServer(grpcSrv, otlpGRPCReceiver{receiver: rc})
+		g.Add(func() error {
+			l, err := net.Listen("tcp", receiveGRPCAddr)
+			if err != nil {
+				return errors.Wrap(err, "listening for gRPC")
+			}
+			slog.Info("starting gRPC server", "address", receiveGRPCAddr, "mode", "receive")
+			return errors.Wrap(grpcSrv.Serve(l), "serving gRPC")
+		}, func(error) {
+			grpcSrv.Stop()
+		})
+	}
+	g.Add(run.SignalHandler(context.Background(), syscall.SIGINT, syscall.SIGTERM))
+	err := g.Run()
+	var sigErr run.SignalError
+	if errors.As(err, &sigErr) {
+		slog.Info("received signal, shutting down")
+		return nil
+	}
+	return err
+}
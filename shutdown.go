@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/oklog/run"
+	"github.com/saswatamcode/pingpong/extprobe"
+)
+
+// addPreStop adds an actor to the run group that, on shutdown, fails readiness and waits for
+// --shutdown-delay. It has to be added first: the group interrupts actors one after another
+// in the order they were added, so all others keep running until the delay passed.
+func addPreStop(g *run.Group, prober *extprobe.Prober) {
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		<-ctx.Done()
+		return nil
+	}, func(error) {
+		prober.ShutDown()
+		if shutdownDelay > 0 {
+			slog.Info("failing readiness before shutting down", "delay", shutdownDelay)
+			time.Sleep(shutdownDelay)
+		}
+		cancel()
+	})
+}
+
+// shutdownServer gracefully shuts down srv, waiting up to --shutdown-drain-timeout for
+// in-flight requests before closing their connections.
+func shutdownServer(srv *http.Server) {
+	slog.Info("shutting down HTTP server", "address", srv.Addr, "drain_timeout", shutdownDrainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownDrainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("in-flight requests did not finish in time, closing connections", "error", err)
+		if err := srv.Close(); err != nil {
+			slog.Error("failed to stop web server", "error", err)
+		}
+	}
+}